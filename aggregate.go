@@ -0,0 +1,131 @@
+package gostan
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+)
+
+// AggregateOptions tells Aggregate how to group the rows. The rows are the ones the ReadCondition lets through,
+// its stop conditions end the window e.g. StopIfColValuesDiffer on the date for the latest day
+type AggregateOptions struct {
+	GroupBy ColumnNames // the rows are counted by the values of these columns, all of them in one group if empty
+	Sum     ColumnNames // summed by group, values that aren't numbers are left out
+}
+
+// AggregateResult holds the groups, in the order they are first found: the group of the latest row first
+type AggregateResult struct {
+	GroupBy   ColumnNames
+	Sum       ColumnNames
+	Groups    []Group
+	Malformed int64 // rows that cannot be parsed into columns, left out of the groups
+}
+
+// Group is the count and sums of the rows with the same values in the GroupBy columns
+type Group struct {
+	Key   []string // values of the GroupBy columns
+	Count int64
+	Sums  []float64 // in the order of AggregateOptions.Sum
+}
+
+// AggregateFiles groups the last rows of local file(s), given oldest first.
+// Unless ReadCondition.Header says otherwise, the first line of the files is their header
+func AggregateFiles(readCondition *ReadCondition, opts AggregateOptions, file_descriptors ...*os.File) (*AggregateResult, error) {
+	return NewFileIterator(withHeader(readCondition), file_descriptors...).Aggregate(opts)
+}
+
+// AggregateBlob groups the last rows of a file on Azure blob storage, like AggregateFiles
+func AggregateBlob(blobClient BlobClient, bufferSize int64, readCondition *ReadCondition, opts AggregateOptions) (*AggregateResult, error) {
+	return NewBlobIterator(blobClient, bufferSize, withHeader(readCondition)).Aggregate(opts)
+}
+
+// Aggregate reads the remaining rows and counts and sums them by group. Columns are named like in the conditions.
+// The header is only read if the ReadCondition needs it, see HeaderDefault. The iterator is closed once done
+func (it *RecordIterator) Aggregate(opts AggregateOptions) (*AggregateResult, error) {
+	defer it.Close()
+	result := &AggregateResult{GroupBy: opts.GroupBy, Sum: opts.Sum}
+	index := map[string]int{}
+	key := make([]string, len(opts.GroupBy))
+	for it.Next() {
+		rec := it.Record()
+		if rec.SchemaChange {
+			continue
+		}
+		r := it.checker.newRow(bytes.TrimSuffix(rec.Data, it.delim))
+		if r.Malformed() {
+			result.Malformed++
+			continue
+		}
+		for i, colName := range opts.GroupBy {
+			key[i], _ = r.Column(colName)
+		}
+		k := joinKey(key)
+		i, ok := index[k]
+		if !ok {
+			i = len(result.Groups)
+			index[k] = i
+			result.Groups = append(result.Groups, Group{
+				Key:  append([]string{}, key...),
+				Sums: make([]float64, len(opts.Sum)),
+			})
+		}
+		g := &result.Groups[i]
+		g.Count++
+		for j, colName := range opts.Sum {
+			v, _ := r.Column(colName)
+			if f, ok := parseNumber(v); ok {
+				g.Sums[j] += f
+			}
+		}
+	}
+	return result, it.Err()
+}
+
+// Header returns the names of the columns of the result: the GroupBy columns, count and sum(column) for every Sum
+func (r *AggregateResult) Header() []string {
+	header := append([]string{}, r.GroupBy...)
+	header = append(header, "count")
+	for _, colName := range r.Sum {
+		header = append(header, "sum("+colName+")")
+	}
+	return header
+}
+
+// Write writes the groups out in the format, with the header but for FormatNDJSON where the keys carry it.
+// NDJSON counts and sums are numbers
+func (r *AggregateResult) Write(w io.Writer, format OutputFormat) error {
+	header := r.Header()
+	if format == FormatNDJSON {
+		for _, g := range r.Groups {
+			mapped_row := make(map[string]interface{}, len(header))
+			for i, v := range g.Key {
+				mapped_row[header[i]] = v
+			}
+			mapped_row["count"] = g.Count
+			for i, colName := range r.Sum {
+				mapped_row["sum("+colName+")"] = g.Sums[i]
+			}
+			if _, err := w.Write(append(jsonProject(mapped_row, header), '\n')); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	rw := NewRowWriter(w, format, header)
+	if err := rw.WriteHeader(header); err != nil {
+		return err
+	}
+	for _, g := range r.Groups {
+		fields := append([]string{}, g.Key...)
+		fields = append(fields, strconv.FormatInt(g.Count, 10))
+		for _, sum := range g.Sums {
+			fields = append(fields, strconv.FormatFloat(sum, 'f', -1, 64))
+		}
+		if err := rw.WriteRow(fields); err != nil {
+			return err
+		}
+	}
+	return rw.Flush()
+}
@@ -0,0 +1,329 @@
+// gostan command prints files or an Azure blob from the end, like tac, and stops on the given conditions.
+//
+//	gostan [flags] [file ... | az://account/container/blob]
+//
+// With no source, or "-", it reads stdin. Stdin is spooled to a temp file first since it has to be read from the end.
+// Blobs use the AZURE_STORAGE_KEY (shared key) or AZURE_STORAGE_SAS_TOKEN environment variable, or no credential at all
+// for public containers
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/zarulzakuan/gostan"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "gostan:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("gostan", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: gostan [flags] [file ... | az://account/container/blob]")
+		flags.PrintDefaults()
+	}
+	rowLimit := flags.Int64("n", 0, "print at most this many rows (0 means no limit)")
+	skip := flags.Int64("skip", 0, "skip this many rows before printing")
+	maxScanned := flags.Int64("max-bytes-scanned", 0, "stop after reading this many bytes of the sources and fail as the rows are partial (0 means no limit)")
+	maxEmitted := flags.Int64("max-bytes-emitted", 0, "stop before printing more than this many bytes of rows as they are in the sources, like --max-bytes-scanned")
+	maxDuration := flags.Duration("max-duration", 0, "stop after reading for this long e.g. 30s, like --max-bytes-scanned")
+	stopRegex := flags.String("stop-regex", "", "stop at the first row matching this regex")
+	stopColDiff := flags.String("stop-col-diff", "", "stop when the values of these comma separated columns differ from the last row's")
+	includeHeader := flags.Bool("include-header", false, "print the header of the first source first")
+	header := flags.String("header", "default", "header line of the sources: default, first, none or detect")
+	columns := flags.String("columns", "", "comma separated names of the columns, for sources without header")
+	headerMismatch := flags.String("header-mismatch", "ignore", "when a source doesn't have the header of the first one: ignore, fail, remap or mark")
+	filter := flags.String("filter", "", "only print rows matching this regex")
+	where := flags.String("where", "", `only print rows matching this condition, e.g. 'status == "FAILED" && region != "eu"'`)
+	stopWhen := flags.String("stop-when", "", `stop at the first row matching this condition, e.g. 'date < "8/25/2022"'`)
+	selectCols := flags.String("select", "", "only print these comma separated columns")
+	dedupBy := flags.String("dedup-by", "", "only print the latest row of every value of these comma separated columns")
+	dedupCache := flags.Int("dedup-cache", 0, "with --dedup-by, remember at most this many keys (0 means all of them)")
+	delimiter := flags.String("d", `\n`, `line delimiter, escapes such as \r\n are understood`)
+	separator := flags.String("s", ",", "column separator, a single character")
+	jsonLines := flags.Bool("json", false, "read JSON Lines, columns are dotted field paths")
+	format := flags.String("format", "raw", "output format: raw, csv, ndjson, markdown or table")
+	bufferSize := flags.Int64("buffer", gostan.MAX_LENGTH, "read size in bytes")
+	adaptiveBuffer := flags.Bool("adaptive-buffer", false, "grow the read size for long lines and for blobs")
+	mmap := flags.Bool("mmap", false, "map local files in memory instead of reading them")
+	encoding := flags.String("encoding", "auto", "encoding of the sources: auto (from the BOM), utf-8, utf-16le or utf-16be")
+	trimNUL := flags.Bool("trim-nul", false, "trim NUL padding off the rows and skip rows of padding only")
+	skipBlank := flags.Bool("skip-blank", false, "skip empty and whitespace only lines")
+	summary := flags.Bool("summary", false, "print a summary of the columns of the rows instead of the rows")
+	topK := flags.Int("top", 5, "most frequent values shown per column with --summary")
+	timeCol := flags.String("time-col", "", "with --summary and --window, the column holding the time of the rows")
+	groupBy := flags.String("group-by", "", "print the count of rows by the values of these comma separated columns instead of the rows")
+	sum := flags.String("sum", "", "with --group-by, comma separated columns to sum by group")
+	window := flags.Duration("window", 0, "with --summary, only summarize the rows within this long of the latest one, e.g. 1h")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	readCondition := &gostan.ReadCondition{
+		RowLimit:        *rowLimit,
+		Skip:            *skip,
+		IncludeHeader:   *includeHeader,
+		JSONLines:       *jsonLines,
+		BufferSize:      *bufferSize,
+		AdaptiveBuffer:  *adaptiveBuffer,
+		Mmap:            *mmap,
+		TrimNUL:         *trimNUL,
+		SkipBlankLines:  *skipBlank,
+		MaxBytesScanned: *maxScanned,
+		MaxBytesEmitted: *maxEmitted,
+		MaxDuration:     *maxDuration,
+	}
+	var err error
+	if *stopRegex != "" {
+		if readCondition.StopIfRegexMatched, err = regexp.Compile(*stopRegex); err != nil {
+			return err
+		}
+	}
+	if *filter != "" {
+		if readCondition.RegexFilter, err = regexp.Compile(*filter); err != nil {
+			return err
+		}
+	}
+	if *where != "" {
+		if readCondition.Where, err = gostan.ParseExpr(*where); err != nil {
+			return err
+		}
+	}
+	if *stopWhen != "" {
+		if readCondition.StopWhen, err = gostan.ParseExpr(*stopWhen); err != nil {
+			return err
+		}
+	}
+	if *stopColDiff != "" {
+		readCondition.StopIfColValuesDiffer = strings.Split(*stopColDiff, ",")
+	}
+	if *selectCols != "" {
+		readCondition.SelectColumns = strings.Split(*selectCols, ",")
+	}
+	if *dedupBy != "" {
+		readCondition.DedupBy = strings.Split(*dedupBy, ",")
+		readCondition.DedupCacheSize = *dedupCache
+	}
+	if *columns != "" {
+		readCondition.Columns = strings.Split(*columns, ",")
+	}
+	if readCondition.Header, err = gostan.ParseHeaderMode(*header); err != nil {
+		return err
+	}
+	if readCondition.OnHeaderMismatch, err = gostan.ParseHeaderMismatchPolicy(*headerMismatch); err != nil {
+		return err
+	}
+	if readCondition.Delimiter, err = unescape(*delimiter); err != nil {
+		return fmt.Errorf("invalid delimiter: %w", err)
+	}
+	sep, err := unescape(*separator)
+	if err != nil || len(sep) != 1 {
+		return fmt.Errorf("invalid separator %q, it has to be a single character", *separator)
+	}
+	readCondition.Separator = sep[0]
+	if readCondition.Format, err = gostan.ParseOutputFormat(*format); err != nil {
+		return err
+	}
+	if readCondition.Encoding, err = gostan.ParseEncoding(*encoding); err != nil {
+		return err
+	}
+
+	sources := flags.Args()
+	if *summary {
+		opts := gostan.SummaryOptions{TopK: *topK, TimeColumn: *timeCol, Window: *window}
+		var s *gostan.Summary
+		err := readSources(sources, stdin, func(blobClient gostan.BlobClient) (err error) {
+			s, err = gostan.SummarizeBlob(blobClient, *bufferSize, readCondition, opts)
+			return err
+		}, func(file_descriptors []*os.File) (err error) {
+			s, err = gostan.SummarizeFiles(readCondition, opts, file_descriptors...)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return printSummary(stdout, s)
+	}
+	if *groupBy != "" || *sum != "" {
+		opts := gostan.AggregateOptions{}
+		if *groupBy != "" {
+			opts.GroupBy = strings.Split(*groupBy, ",")
+		}
+		if *sum != "" {
+			opts.Sum = strings.Split(*sum, ",")
+		}
+		var result *gostan.AggregateResult
+		err := readSources(sources, stdin, func(blobClient gostan.BlobClient) (err error) {
+			result, err = gostan.AggregateBlob(blobClient, *bufferSize, readCondition, opts)
+			return err
+		}, func(file_descriptors []*os.File) (err error) {
+			result, err = gostan.AggregateFiles(readCondition, opts, file_descriptors...)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return result.Write(stdout, readCondition.Format)
+	}
+
+	r, w := io.Pipe()
+	if len(sources) == 1 && strings.HasPrefix(sources[0], "az://") {
+		blobClient, err := newBlobClient(sources[0])
+		if err != nil {
+			return err
+		}
+		go gostan.ReverseReadBlob(w, blobClient, *bufferSize, readCondition)
+	} else {
+		file_descriptors, cleanup, err := openFiles(sources, stdin)
+		defer cleanup()
+		if err != nil {
+			return err
+		}
+		go gostan.ReverseReadFiles(w, readCondition, file_descriptors...)
+	}
+
+	_, err = io.Copy(stdout, r)
+	return err
+}
+
+// readSources calls blob with the client of the source if it's a blob, or files with the files otherwise.
+// The files are closed after the call
+func readSources(sources []string, stdin io.Reader, blob func(gostan.BlobClient) error, files func([]*os.File) error) error {
+	if len(sources) == 1 && strings.HasPrefix(sources[0], "az://") {
+		blobClient, err := newBlobClient(sources[0])
+		if err != nil {
+			return err
+		}
+		return blob(blobClient)
+	}
+	file_descriptors, cleanup, err := openFiles(sources, stdin)
+	defer cleanup()
+	for _, fd := range file_descriptors {
+		defer fd.Close()
+	}
+	if err != nil {
+		return err
+	}
+	return files(file_descriptors)
+}
+
+// printSummary prints the summary as a table, a line per column
+func printSummary(stdout io.Writer, s *gostan.Summary) error {
+	fmt.Fprintf(stdout, "%d rows, %d malformed\n", s.Rows, s.Malformed)
+	table := gostan.NewRowWriter(stdout, gostan.FormatTable, nil)
+	if err := table.WriteHeader([]string{"column", "kind", "count", "distinct", "min", "max", "top"}); err != nil {
+		return err
+	}
+	for _, col := range s.Columns {
+		top := make([]string, len(col.Top))
+		for i, v := range col.Top {
+			top[i] = fmt.Sprintf("%s (%d)", v.Value, v.Count)
+		}
+		err := table.WriteRow([]string{col.Name, col.Kind.String(), strconv.FormatInt(col.Count, 10),
+			strconv.FormatInt(col.Distinct, 10), col.Min, col.Max, strings.Join(top, ", ")})
+		if err != nil {
+			return err
+		}
+	}
+	return table.Flush()
+}
+
+// openFiles opens the local sources, stdin is spooled to a temp file. cleanup removes the temp file
+func openFiles(sources []string, stdin io.Reader) ([]*os.File, func(), error) {
+	var spooled string
+	cleanup := func() {
+		if spooled != "" {
+			os.Remove(spooled)
+		}
+	}
+	if len(sources) == 0 {
+		sources = []string{"-"}
+	}
+
+	file_descriptors := []*os.File{}
+	for _, name := range sources {
+		if strings.HasPrefix(name, "az://") {
+			return nil, cleanup, errors.New("only one blob can be read at a time, and not together with files")
+		}
+		if name == "-" {
+			if spooled != "" {
+				return nil, cleanup, errors.New("stdin can only be given once")
+			}
+			fd, err := spool(stdin)
+			if err != nil {
+				return nil, cleanup, err
+			}
+			spooled = fd.Name()
+			file_descriptors = append(file_descriptors, fd)
+			continue
+		}
+		fd, err := os.Open(name)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		file_descriptors = append(file_descriptors, fd)
+	}
+	return file_descriptors, cleanup, nil
+}
+
+// spool copies stdin to a temp file so it can be read from the end
+func spool(stdin io.Reader) (*os.File, error) {
+	fd, err := os.CreateTemp("", "gostan-stdin-*")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(fd, stdin); err != nil {
+		fd.Close()
+		os.Remove(fd.Name())
+		return nil, err
+	}
+	return fd, nil
+}
+
+// newBlobClient makes the client for an az://account/container/blob URL
+func newBlobClient(source string) (*azblob.BlockBlobClient, error) {
+	account, path, ok := strings.Cut(strings.TrimPrefix(source, "az://"), "/")
+	if !ok || account == "" || !strings.Contains(path, "/") {
+		return nil, fmt.Errorf("invalid blob %q, expected az://account/container/blob", source)
+	}
+	blobURL := fmt.Sprintf("https://%s.blob.core.windows.net/%s", account, path)
+
+	if key := os.Getenv("AZURE_STORAGE_KEY"); key != "" {
+		cred, err := azblob.NewSharedKeyCredential(account, key)
+		if err != nil {
+			return nil, err
+		}
+		return azblob.NewBlockBlobClientWithSharedKey(blobURL, cred, nil)
+	}
+	if sas := os.Getenv("AZURE_STORAGE_SAS_TOKEN"); sas != "" {
+		return azblob.NewBlockBlobClientWithNoCredential(blobURL+"?"+strings.TrimPrefix(sas, "?"), nil)
+	}
+	return azblob.NewBlockBlobClientWithNoCredential(blobURL, nil)
+}
+
+// unescape turns escapes such as \n or \t typed on the command line into the characters
+func unescape(s string) ([]byte, error) {
+	if s == "" {
+		return nil, errors.New("empty")
+	}
+	unquoted, err := strconv.Unquote(`"` + strings.ReplaceAll(s, `"`, `\"`) + `"`)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(unquoted), nil
+}
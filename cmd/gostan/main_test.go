@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		args         []string
+		stdin        string
+		control_text string
+	}{
+		{[]string{"-n", "2", "--include-header", "../../mockfile1"}, "", `id,date,name
+10,8/24/2022,Dagon
+9,8/24/2022,Welfare
+`},
+		{[]string{"--stop-col-diff", "date", "--include-header", "../../mockfile1", "../../mockfile2"}, "", `id,date,name
+10,8/25/2022,Truelove
+9,8/25/2022,Druery
+8,8/25/2022,Laux
+7,8/25/2022,Arghent
+6,8/25/2022,Monketon
+5,8/25/2022,Tabourin
+4,8/25/2022,Dowty
+3,8/25/2022,Canet
+2,8/25/2022,Withur
+1,8/25/2022,Schoenleiter
+`},
+		{[]string{"--filter", "Ca|Ch", "--stop-regex", "Rainger", "--include-header", "../../mockfile1", "../../mockfile2"}, "", `id,date,name
+3,8/25/2022,Canet
+5,8/24/2022,Chaucer
+`},
+		// stdin, custom delimiter and separator
+		{[]string{"-d", `\r\n`, "-s", ";", "--select", "b", "--include-header"}, "a;b\r\n1;x\r\n2;y\r\n3;z", "b\r\nz\r\ny\r\nx\r\n"},
+		{[]string{"-n", "1", "--format", "ndjson", "-"}, "id,name\n1,a\n2,b\n", `{"id":"2","name":"b"}` + "\n"},
+		{[]string{"--group-by", "date", "--stop-col-diff", "date", "--format", "table", "../../mockfile1", "../../mockfile2"}, "", `date       count
+---------  -----
+8/25/2022  10
+`},
+		{[]string{"--summary", "--top", "1", "-"}, "id,name\n1,a\n2,b\n3,b\n", `3 rows, 0 malformed
+column  kind    count  distinct  min  max  top
+------  ------  -----  --------  ---  ---  -----
+id      number  3      3         1    3    1 (1)
+name    text    3      2                   b (2)
+`},
+	}
+
+	for _, test := range tests {
+		var stdout bytes.Buffer
+		if err := run(test.args, strings.NewReader(test.stdin), &stdout); err != nil {
+			t.Errorf("%v: %s", test.args, err)
+			continue
+		}
+		if stdout.String() != test.control_text {
+			t.Errorf("%v: got %q", test.args, stdout.String())
+		}
+	}
+}
+
+func TestRunInvalidArgs(t *testing.T) {
+	for _, args := range [][]string{
+		{"-s", ";;", "../../mockfile1"},
+		{"--format", "xml", "../../mockfile1"},
+		{"--stop-regex", "(", "../../mockfile1"},
+		{"az://account"},
+		{"../../mockfile1", "az://account/container/blob"},
+		{"./does-not-exist"},
+	} {
+		var stdout bytes.Buffer
+		if err := run(args, strings.NewReader(""), &stdout); err == nil {
+			t.Errorf("%v should fail", args)
+		}
+	}
+}
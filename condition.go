@@ -0,0 +1,145 @@
+package gostan
+
+import (
+	"bytes"
+)
+
+// rowChecker evaluates ReadCondition against every reversed row.
+// Both readers feed their rows through it so the conditions only live in one place
+type rowChecker struct {
+	cond      *ReadCondition
+	headers   [][]byte
+	delim     []byte
+	sep       byte
+	compare   string
+	compared  bool
+	row_count int64
+	skipped   int64
+	dedup     *keyCache // keys let out by DedupBy
+}
+
+func newRowChecker(readCondition *ReadCondition, headers [][]byte) *rowChecker {
+	c := &rowChecker{
+		cond:    readCondition,
+		headers: headers,
+		delim:   readCondition.delimiter(),
+		sep:     readCondition.separator(),
+	}
+	if readCondition.DedupBy != nil {
+		c.dedup = newKeyCache(readCondition.DedupCacheSize)
+	}
+	return c
+}
+
+// check tells whether the row should be written out and whether the reader should stop
+func (c *rowChecker) check(row []byte) (bool, bool) {
+	line := bytes.TrimSuffix(row, c.delim)
+	r := c.newRow(line)
+
+	malformed := false
+	if c.needColumns() {
+		malformed = r.Malformed()
+		if malformed {
+			switch c.cond.OnMalformed {
+			case MalformedSkip:
+				return false, false
+			case MalformedStop:
+				return false, true
+			}
+		}
+	}
+
+	// CONDITION 1
+	if c.cond.StopIfColValuesDiffer != nil && !malformed {
+		values := ""
+		for _, colName := range c.cond.StopIfColValuesDiffer {
+			v, _ := r.Column(colName)
+			values += v
+		}
+		// if we havent store the values, store it. Else, compare
+		if !c.compared {
+			c.compare = values
+			c.compared = true
+		} else if values != c.compare {
+			return false, true
+		}
+	}
+
+	// CONDITION 2
+	if c.cond.StopIfRegexMatched != nil && c.cond.StopIfRegexMatched.Match(line) {
+		return false, true
+	}
+
+	// CONDITION 3, user supplied
+	if c.cond.StopIf != nil && c.cond.StopIf(r) {
+		return false, true
+	}
+	if c.cond.StopWhen != nil && c.cond.StopWhen.Eval(r) {
+		return false, true
+	}
+
+	// the latest row of a key is the one that counts, the older ones are skipped whether it made it through the filters or not
+	if c.dedup != nil && !malformed && c.dedup.seen(dedupKey(r, c.cond.DedupBy)) {
+		return false, false
+	}
+
+	// filters
+	if c.cond.RegexFilter != nil && !c.cond.RegexFilter.Match(line) {
+		return false, false
+	}
+	if !malformed {
+		for colName, re := range c.cond.ColumnFilter {
+			v, ok := r.Column(colName)
+			if !ok || !re.MatchString(v) {
+				return false, false
+			}
+		}
+	}
+	if c.cond.Keep != nil && !c.cond.Keep(r) {
+		return false, false
+	}
+	if c.cond.Where != nil && !c.cond.Where.Eval(r) {
+		return false, false
+	}
+
+	// pagination, the first Skip rows that made it through the filters are not written out
+	if c.skipped < c.cond.Skip {
+		c.skipped++
+		return false, false
+	}
+
+	// CONDITION 4
+	if c.cond.RowLimit > 0 && c.cond.RowLimit == c.row_count {
+		return false, true
+	}
+	c.row_count++
+
+	return true, false
+}
+
+// needColumns tells whether the row has to be parsed into columns for the conditions.
+// JSON Lines are also parsed when malformed lines are not to be written out
+func (c *rowChecker) needColumns() bool {
+	if c.cond.JSONLines && c.cond.OnMalformed != MalformedEmitRaw {
+		return true
+	}
+	return c.cond.StopIfColValuesDiffer != nil || c.cond.SelectColumns != nil || c.cond.ColumnFilter != nil ||
+		c.cond.DedupBy != nil
+}
+
+// newRow wraps the line, without its delimiter, for the column lookups
+func (c *rowChecker) newRow(line []byte) Row {
+	return newRow(line, c.headers, c.sep, c.cond.JSONLines)
+}
+
+// outputHeader returns the names of the columns that get written out
+func (c *rowChecker) outputHeader() []string {
+	if c.cond.SelectColumns != nil {
+		return c.cond.SelectColumns
+	}
+	header := make([]string, len(c.headers))
+	for i, h := range c.headers {
+		header[i] = string(h)
+	}
+	return header
+}
@@ -0,0 +1,35 @@
+package gostan
+
+import (
+	"fmt"
+)
+
+// Cursor is the position a reverse read got to. Give it back as ReadCondition.StartAt
+// to carry on with the rows older than the ones already read, e.g. to resume after a crash
+// or to fetch the next page
+type Cursor struct {
+	Source int   // index of the source in the list given to the reader
+	Offset int64 // everything before this byte offset in the source is still to be read
+}
+
+// AtStart tells whether there's nothing older left to read
+func (c Cursor) AtStart() bool {
+	return c.Source <= 0 && c.Offset <= 0
+}
+
+// String returns the cursor as an opaque token that can be handed out and parsed back with ParseCursor
+func (c Cursor) String() string {
+	return fmt.Sprintf("%d:%d", c.Source, c.Offset)
+}
+
+// ParseCursor parses the token returned by Cursor.String
+func ParseCursor(token string) (Cursor, error) {
+	var c Cursor
+	if _, err := fmt.Sscanf(token, "%d:%d", &c.Source, &c.Offset); err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor %q", token)
+	}
+	if c.Source < 0 || c.Offset < 0 {
+		return Cursor{}, fmt.Errorf("invalid cursor %q", token)
+	}
+	return c, nil
+}
@@ -0,0 +1,51 @@
+package gostan
+
+import (
+	"container/list"
+)
+
+// keyCache remembers the keys of the rows let out by DedupBy. With a limit, the least recently seen keys are
+// forgotten first, otherwise all of them are kept
+type keyCache struct {
+	limit int
+	keys  map[string]*list.Element
+	order *list.List // most recently seen at the front, only with a limit
+}
+
+func newKeyCache(limit int) *keyCache {
+	c := &keyCache{limit: limit, keys: map[string]*list.Element{}}
+	if limit > 0 {
+		c.order = list.New()
+	}
+	return c
+}
+
+// seen tells whether the key was seen before, and remembers it
+func (c *keyCache) seen(key string) bool {
+	if e, ok := c.keys[key]; ok {
+		if c.order != nil {
+			c.order.MoveToFront(e)
+		}
+		return true
+	}
+	if c.order == nil {
+		c.keys[key] = nil
+		return false
+	}
+	c.keys[key] = c.order.PushFront(key)
+	if c.order.Len() > c.limit {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.keys, oldest.Value.(string))
+	}
+	return false
+}
+
+// dedupKey returns the values of the DedupBy columns of the row, as one string
+func dedupKey(r Row, columns ColumnNames) string {
+	values := make([]string, len(columns))
+	for i, colName := range columns {
+		values[i], _ = r.Column(colName)
+	}
+	return joinKey(values)
+}
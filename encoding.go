@@ -0,0 +1,129 @@
+package gostan
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Encoding is the text encoding of the sources. Rows are always given out in UTF-8
+type Encoding int
+
+const (
+	EncodingAuto    Encoding = iota // from the BOM of every source, UTF-8 when there's none
+	EncodingUTF8                    // a UTF-8 BOM is still skipped
+	EncodingUTF16LE                 // transcoded to UTF-8
+	EncodingUTF16BE                 // transcoded to UTF-8
+)
+
+var (
+	bom_utf8    = []byte{0xEF, 0xBB, 0xBF}
+	bom_utf16le = []byte{0xFF, 0xFE}
+	bom_utf16be = []byte{0xFE, 0xFF}
+)
+
+func (e Encoding) String() string {
+	switch e {
+	case EncodingAuto:
+		return "auto"
+	case EncodingUTF8:
+		return "utf-8"
+	case EncodingUTF16LE:
+		return "utf-16le"
+	case EncodingUTF16BE:
+		return "utf-16be"
+	}
+	return fmt.Sprintf("Encoding(%d)", int(e))
+}
+
+// ParseEncoding returns the Encoding of its name, as returned by String
+func ParseEncoding(name string) (Encoding, error) {
+	for _, e := range []Encoding{EncodingAuto, EncodingUTF8, EncodingUTF16LE, EncodingUTF16BE} {
+		if e.String() == name {
+			return e, nil
+		}
+	}
+	return EncodingAuto, fmt.Errorf("unknown encoding %q, expected auto, utf-8, utf-16le or utf-16be", name)
+}
+
+// detectEncoding looks for a BOM at the start of the source. It returns the encoding, the declared one
+// unless it's EncodingAuto, and the length of the BOM to skip
+func detectEncoding(src source, declared Encoding) (Encoding, int64, error) {
+	size, err := src.size()
+	if err != nil {
+		return declared, 0, err
+	}
+	head := make([]byte, 3)
+	if size < 3 {
+		head = head[:size]
+	}
+	if err := readFull(src, head, 0); err != nil {
+		return declared, 0, err
+	}
+
+	found, bom := EncodingUTF8, 0
+	switch {
+	case bytes.HasPrefix(head, bom_utf8):
+		found, bom = EncodingUTF8, len(bom_utf8)
+	case bytes.HasPrefix(head, bom_utf16le):
+		found, bom = EncodingUTF16LE, len(bom_utf16le)
+	case bytes.HasPrefix(head, bom_utf16be):
+		found, bom = EncodingUTF16BE, len(bom_utf16be)
+	}
+	if declared == EncodingAuto {
+		return found, int64(bom), nil
+	}
+	// only skip the BOM that goes with the declared encoding
+	if found != declared {
+		bom = 0
+	}
+	return declared, int64(bom), nil
+}
+
+// unit is the size of a code unit, lines can only start at a multiple of it
+func (e Encoding) unit() int {
+	if e == EncodingUTF16LE || e == EncodingUTF16BE {
+		return 2
+	}
+	return 1
+}
+
+// encode converts UTF-8 text such as the delimiter to the encoding
+func (e Encoding) encode(s []byte) []byte {
+	if e.unit() == 1 {
+		return s
+	}
+	units := utf16.Encode([]rune(string(s)))
+	out := make([]byte, 0, 2*len(units))
+	for _, u := range units {
+		if e == EncodingUTF16LE {
+			out = append(out, byte(u), byte(u>>8))
+		} else {
+			out = append(out, byte(u>>8), byte(u))
+		}
+	}
+	return out
+}
+
+// decode appends the text converted to UTF-8 to dst. A trailing odd byte becomes U+FFFD
+func (e Encoding) decode(dst []byte, s []byte) []byte {
+	if e.unit() == 1 {
+		return append(dst, s...)
+	}
+	units := make([]uint16, len(s)/2)
+	for i := range units {
+		if e == EncodingUTF16LE {
+			units[i] = uint16(s[2*i]) | uint16(s[2*i+1])<<8
+		} else {
+			units[i] = uint16(s[2*i])<<8 | uint16(s[2*i+1])
+		}
+	}
+	for _, r := range utf16.Decode(units) {
+		dst = utf8.AppendRune(dst, r)
+	}
+	if len(s)%2 != 0 {
+		dst = utf8.AppendRune(dst, utf8.RuneError)
+	}
+	return dst
+}
@@ -0,0 +1,389 @@
+package gostan
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Expr is a condition over the columns of a row, parsed from a string by ParseExpr such as
+//
+//	status == "FAILED" && region != "eu"
+//	date < "8/25/2022" || !(name =~ "^Ch")
+//
+// Columns are named by their header name or position ($1 is the first one), or field path for JSON Lines. Names that aren't plain words can be
+// put in backquotes: `first name`. Comparison operators are == != < <= > >= and =~ !~ for regex match.
+// Values compare as numbers if both are numbers, as dates if both are dates (2006-01-02, RFC 3339 or month first 1/2/2006),
+// and as strings otherwise.
+// && (and), || (or), ! (not) and parentheses combine them
+type Expr struct {
+	source  string
+	root    exprNode
+	columns ColumnNames // the columns it looks at
+}
+
+// ParseExpr parses a condition expression
+func ParseExpr(s string) (*Expr, error) {
+	p := &exprParser{input: s}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at %d in %q", tok.text, tok.pos, s)
+	}
+	return &Expr{source: s, root: root, columns: p.columns}, nil
+}
+
+// MustParseExpr is like ParseExpr but panics if the expression cannot be parsed
+func MustParseExpr(s string) *Expr {
+	e, err := ParseExpr(s)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// Eval evaluates the expression against the row
+func (e *Expr) Eval(row Row) bool {
+	return e.root.eval(row)
+}
+
+// namesColumns tells whether the expression looks a column up by name rather than by position
+func (e *Expr) namesColumns() bool {
+	return e != nil && e.columns.byName()
+}
+
+func (e *Expr) String() string {
+	return e.source
+}
+
+type exprNode interface {
+	eval(row Row) bool
+}
+
+type andNode struct{ left, right exprNode }
+type orNode struct{ left, right exprNode }
+type notNode struct{ node exprNode }
+
+func (n andNode) eval(row Row) bool { return n.left.eval(row) && n.right.eval(row) }
+func (n orNode) eval(row Row) bool  { return n.left.eval(row) || n.right.eval(row) }
+func (n notNode) eval(row Row) bool { return !n.node.eval(row) }
+
+// operand is either a column or a literal value
+type operand struct {
+	column string
+	value  string
+	is_col bool
+}
+
+func (o operand) get(row Row) string {
+	if !o.is_col {
+		return o.value
+	}
+	v, _ := row.Column(o.column)
+	return v
+}
+
+type compareNode struct {
+	op          string
+	left, right operand
+	re          *regexp.Regexp // for =~ and !~
+}
+
+func (n compareNode) eval(row Row) bool {
+	left := n.left.get(row)
+	switch n.op {
+	case "=~":
+		return n.re.MatchString(left)
+	case "!~":
+		return !n.re.MatchString(left)
+	}
+	c := compareValues(left, n.right.get(row))
+	switch n.op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default: // ">="
+		return c >= 0
+	}
+}
+
+// compareValues compares two values as numbers, dates or strings, whichever both of them are
+func compareValues(a string, b string) int {
+	if fa, ok := parseNumber(a); ok {
+		if fb, ok := parseNumber(b); ok {
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			}
+			return 0
+		}
+	}
+	if ta, ok := parseDate(a); ok {
+		if tb, ok := parseDate(b); ok {
+			switch {
+			case ta.Before(tb):
+				return -1
+			case ta.After(tb):
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+var date_layouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"1/2/2006 15:04:05",
+	"1/2/2006",
+}
+
+// parseDate parses the common date layouts found in exports. Slashed dates are month first, a value is never
+// read day first as that would order the dates of a column differently from one value to the next
+func parseDate(s string) (time.Time, bool) {
+	for _, layout := range date_layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type exprParser struct {
+	input   string
+	tokens  []token
+	next    int
+	columns ColumnNames
+}
+
+var expr_ops = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!"}
+
+// lex splits the input into tokens
+func (p *exprParser) lex() error {
+	s := p.input
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			p.tokens = append(p.tokens, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			p.tokens = append(p.tokens, token{tokRParen, ")", i})
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(s) && s[end] != byte(c) {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return fmt.Errorf("unterminated string at %d in %q", i, s)
+			}
+			text := s[i+1 : end]
+			if c == '"' {
+				unquoted, err := strconv.Unquote(s[i : end+1])
+				if err != nil {
+					return fmt.Errorf("invalid string at %d in %q", i, s)
+				}
+				text = unquoted
+			}
+			p.tokens = append(p.tokens, token{tokString, text, i})
+			i = end + 1
+		case c == '`':
+			end := strings.IndexByte(s[i+1:], '`')
+			if end < 0 {
+				return fmt.Errorf("unterminated column name at %d in %q", i, s)
+			}
+			p.tokens = append(p.tokens, token{tokIdent, s[i+1 : i+1+end], i})
+			i += end + 2
+		case c == '-' || c == '.' || unicode.IsDigit(c):
+			end := i + 1
+			for end < len(s) && (s[end] == '.' || unicode.IsDigit(rune(s[end])) || s[end] == 'e' || s[end] == 'E') {
+				end++
+			}
+			p.tokens = append(p.tokens, token{tokNumber, s[i:end], i})
+			i = end
+		case c == '_' || c == '$' || unicode.IsLetter(c):
+			end := i + 1
+			for end < len(s) && (s[end] == '_' || s[end] == '.' || unicode.IsLetter(rune(s[end])) || unicode.IsDigit(rune(s[end]))) {
+				end++
+			}
+			word := s[i:end]
+			// and, or, not read the same as their symbols
+			switch strings.ToLower(word) {
+			case "and":
+				p.tokens = append(p.tokens, token{tokOp, "&&", i})
+			case "or":
+				p.tokens = append(p.tokens, token{tokOp, "||", i})
+			case "not":
+				p.tokens = append(p.tokens, token{tokOp, "!", i})
+			default:
+				p.tokens = append(p.tokens, token{tokIdent, word, i})
+			}
+			i = end
+		default:
+			found := false
+			for _, op := range expr_ops {
+				if strings.HasPrefix(s[i:], op) {
+					p.tokens = append(p.tokens, token{tokOp, op, i})
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("unexpected %q at %d in %q", c, i, s)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *exprParser) peek() token {
+	if p.next < len(p.tokens) {
+		return p.tokens[p.next]
+	}
+	return token{kind: tokEOF, text: "end", pos: len(p.input)}
+}
+
+func (p *exprParser) take() token {
+	tok := p.peek()
+	if p.next < len(p.tokens) {
+		p.next++
+	}
+	return tok
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "||" {
+		p.take()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "&&" {
+		p.take()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if tok := p.peek(); tok.kind == tokOp && tok.text == "!" {
+		p.take()
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node}, nil
+	}
+	if p.peek().kind == tokLParen {
+		p.take()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok := p.take(); tok.kind != tokRParen {
+			return nil, fmt.Errorf("expected ) at %d in %q", tok.pos, p.input)
+		}
+		return node, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.take()
+	if tok.kind != tokOp || tok.text == "&&" || tok.text == "||" || tok.text == "!" {
+		return nil, fmt.Errorf("expected comparison operator at %d in %q", tok.pos, p.input)
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	node := compareNode{op: tok.text, left: left, right: right}
+	if tok.text == "=~" || tok.text == "!~" {
+		if right.is_col {
+			return nil, fmt.Errorf("regex at %d in %q has to be a string", tok.pos, p.input)
+		}
+		if node.re, err = regexp.Compile(right.value); err != nil {
+			return nil, fmt.Errorf("invalid regex in %q: %w", p.input, err)
+		}
+	}
+	return node, nil
+}
+
+func (p *exprParser) parseOperand() (operand, error) {
+	tok := p.take()
+	switch tok.kind {
+	case tokIdent:
+		p.columns = append(p.columns, tok.text)
+		return operand{column: tok.text, is_col: true}, nil
+	case tokString, tokNumber:
+		return operand{value: tok.text}, nil
+	}
+	return operand{}, fmt.Errorf("expected column or value at %d in %q, got %q", tok.pos, p.input, tok.text)
+}
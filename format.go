@@ -0,0 +1,444 @@
+package gostan
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// OutputFormat is the format the reversed rows are written out in
+type OutputFormat int
+
+const (
+	FormatRaw      OutputFormat = iota // rows as they are in the source (default)
+	FormatCSV                          // properly quoted CSV
+	FormatNDJSON                       // one JSON object per row keyed by header
+	FormatMarkdown                     // markdown table
+	FormatTable                        // plain text table with aligned columns
+)
+
+// RowWriter writes reversed rows in another format. Rows are given as fields,
+// use NewRowWriter to get one for an OutputFormat
+type RowWriter interface {
+	WriteHeader(header []string) error
+	WriteRow(fields []string) error
+	// Flush writes out anything that is still buffered. Table output is only written on Flush
+	Flush() error
+}
+
+// NewRowWriter returns the RowWriter for the format. The header is needed by FormatNDJSON to key the objects,
+// it doesn't get written out until WriteHeader is called
+func NewRowWriter(w io.Writer, format OutputFormat, header []string) RowWriter {
+	switch format {
+	case FormatCSV:
+		return &csvRowWriter{w: csv.NewWriter(w)}
+	case FormatNDJSON:
+		return &ndjsonRowWriter{w: w, header: header}
+	case FormatMarkdown:
+		return &markdownRowWriter{w: w}
+	case FormatTable:
+		return &tableRowWriter{w: w}
+	default:
+		return &rawRowWriter{w: w}
+	}
+}
+
+// SplitRow splits a CSV row into its fields, unquoting them. The trailing newline is ignored
+func SplitRow(row []byte) []string {
+	return splitRow(bytes.TrimRight(row, "\r\n"), ',')
+}
+
+// splitRow splits a row without its delimiter into its fields
+func splitRow(line []byte, sep byte) []string {
+	if len(line) == 0 {
+		return []string{""}
+	}
+	r := csv.NewReader(bytes.NewReader(line))
+	r.Comma = rune(sep)
+	r.LazyQuotes = true
+	r.FieldsPerRecord = -1
+	fields, err := r.Read()
+	if err != nil || r.InputOffset() < int64(len(line)) {
+		// not something encoding/csv can take, e.g. a quoted newline in a custom delimited file
+		return strings.Split(string(line), string(sep))
+	}
+	return fields
+}
+
+// rowOutput writes the rows that passed the rowChecker out in the format asked by ReadCondition.
+// FormatRaw writes the rows byte for byte, the other formats split them into fields first
+type rowOutput struct {
+	out     io.Writer
+	cond    *ReadCondition
+	checker *rowChecker
+	header  []string
+	writer  RowWriter // nil when the rows are written as they are
+	started bool      // the header is written, it's only written once the first row is read
+	err     error     // first write error, the reader stops once it's set
+}
+
+func newRowOutput(out io.Writer, checker *rowChecker) *rowOutput {
+	o := &rowOutput{
+		out:     out,
+		cond:    checker.cond,
+		checker: checker,
+		header:  checker.outputHeader(),
+	}
+	switch {
+	case o.cond.Format == FormatRaw:
+	case o.cond.JSONLines && (o.cond.Format == FormatNDJSON || o.cond.SelectColumns == nil):
+		// JSON Lines already are NDJSON, and without selected columns there's nothing to lay the other formats out with
+	default:
+		o.writer = NewRowWriter(out, o.cond.Format, o.header)
+	}
+	return o
+}
+
+// start writes the header out before the first row, or at the end if there's none. The header is the one of the
+// source the first row comes from, which may not be the first source with MismatchMark
+func (o *rowOutput) start() {
+	if o.started {
+		return
+	}
+	o.started = true
+	o.setHeader()
+	o.writeHeader()
+}
+
+// setHeader takes the columns of the source being read
+func (o *rowOutput) setHeader() {
+	o.header = o.checker.outputHeader()
+	if nw, ok := o.writer.(*ndjsonRowWriter); ok {
+		nw.header = o.header
+	}
+}
+
+// changeHeader switches to the columns of the source whose header is the marker given out with MismatchMark.
+// Raw rows get the marker as it is, CSV a header with IncludeHeader, tables start over and NDJSON takes the new keys
+func (o *rowOutput) changeHeader(marker []byte) {
+	if !o.started {
+		// nothing written yet, the header written is the marker's
+		o.start()
+		if o.writer != nil || o.cond.IncludeHeader || o.cond.SelectColumns != nil {
+			return
+		}
+		o.write(marker)
+		return
+	}
+	o.setHeader()
+	if o.cond.SelectColumns != nil {
+		// the columns written out are the same
+		return
+	}
+	if o.writer == nil {
+		o.write(marker)
+		return
+	}
+	switch o.cond.Format {
+	case FormatCSV:
+		if o.cond.IncludeHeader {
+			o.setErr(o.writer.WriteHeader(o.header))
+		}
+	case FormatMarkdown, FormatTable:
+		o.setErr(o.writer.Flush())
+		o.write([]byte("\n"))
+		o.setErr(o.writer.WriteHeader(o.header))
+	}
+}
+
+// writeHeader writes the header out if the format needs it. CSV and raw rows only get it with IncludeHeader,
+// NDJSON never as the keys carry it and tables always have it
+func (o *rowOutput) writeHeader() {
+	if o.cond.JSONLines && o.writer == nil {
+		return
+	}
+	if o.writer == nil {
+		if o.cond.IncludeHeader && len(o.header) > 0 {
+			o.write(append([]byte(strings.Join(o.header, string(o.checker.sep))), o.checker.delim...))
+		}
+		return
+	}
+	switch o.cond.Format {
+	case FormatCSV:
+		if !o.cond.IncludeHeader {
+			return
+		}
+	case FormatNDJSON:
+		return
+	}
+	if len(o.header) > 0 {
+		o.setErr(o.writer.WriteHeader(o.header))
+	}
+}
+
+func (o *rowOutput) writeRow(row []byte) {
+	o.start()
+	if o.writer == nil {
+		if o.cond.SelectColumns != nil {
+			row = o.project(row)
+		}
+		o.write(row)
+		if !bytes.HasSuffix(row, o.checker.delim) {
+			// a last line left as it is with ExactLastLine, the next row must not run into it
+			o.write(o.checker.delim)
+		}
+		return
+	}
+	o.setErr(o.writer.WriteRow(o.fields(row)))
+}
+
+func (o *rowOutput) flush() {
+	o.start()
+	if o.writer != nil {
+		o.setErr(o.writer.Flush())
+	}
+}
+
+func (o *rowOutput) write(b []byte) {
+	_, err := o.out.Write(b)
+	o.setErr(err)
+}
+
+// setErr keeps the first error
+func (o *rowOutput) setErr(err error) {
+	if o.err == nil {
+		o.err = err
+	}
+}
+
+// project cuts the raw row down to the selected columns. Rows that cannot be parsed are left as they are
+func (o *rowOutput) project(row []byte) []byte {
+	r := o.checker.newRow(bytes.TrimSuffix(row, o.checker.delim))
+	if r.Malformed() {
+		return row
+	}
+	var projected []byte
+	if o.cond.JSONLines {
+		mapped_row := make(map[string]interface{}, len(o.cond.SelectColumns))
+		for _, colName := range o.cond.SelectColumns {
+			mapped_row[colName], _ = r.Value(colName)
+		}
+		projected = jsonProject(mapped_row, o.cond.SelectColumns)
+	} else {
+		values := make([][]byte, len(o.cond.SelectColumns))
+		for i, colName := range o.cond.SelectColumns {
+			v, _ := r.Column(colName)
+			values[i] = []byte(v)
+		}
+		projected = bytes.Join(values, []byte{o.checker.sep})
+	}
+	return append(projected, o.checker.delim...)
+}
+
+// fields splits the row into the fields to be given to the RowWriter
+func (o *rowOutput) fields(row []byte) []string {
+	line := bytes.TrimSuffix(row, o.checker.delim)
+	if o.cond.JSONLines {
+		r := o.checker.newRow(line)
+		if r.Malformed() {
+			return []string{string(line)}
+		}
+		fields := make([]string, len(o.cond.SelectColumns))
+		for i, colName := range o.cond.SelectColumns {
+			fields[i], _ = r.Column(colName)
+		}
+		return fields
+	}
+
+	all := splitRow(line, o.checker.sep)
+	if o.cond.SelectColumns == nil {
+		return all
+	}
+	fields := make([]string, len(o.cond.SelectColumns))
+	for i, colName := range o.cond.SelectColumns {
+		if j := columnIndex(o.checker.headers, colName); j >= 0 && j < len(all) {
+			fields[i] = all[j]
+		}
+	}
+	return fields
+}
+
+type rawRowWriter struct {
+	w io.Writer
+}
+
+func (rw *rawRowWriter) WriteHeader(header []string) error {
+	return rw.WriteRow(header)
+}
+
+func (rw *rawRowWriter) WriteRow(fields []string) error {
+	_, err := io.WriteString(rw.w, strings.Join(fields, ",")+"\n")
+	return err
+}
+
+func (rw *rawRowWriter) Flush() error {
+	return nil
+}
+
+type csvRowWriter struct {
+	w *csv.Writer
+}
+
+func (cw *csvRowWriter) WriteHeader(header []string) error {
+	return cw.WriteRow(header)
+}
+
+func (cw *csvRowWriter) WriteRow(fields []string) error {
+	if err := cw.w.Write(fields); err != nil {
+		return err
+	}
+	// flush every row so the reader at the other end of the pipe gets it straight away
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func (cw *csvRowWriter) Flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+type ndjsonRowWriter struct {
+	w      io.Writer
+	header []string
+}
+
+// WriteHeader doesn't write anything, the header is carried by the keys of every object
+func (nw *ndjsonRowWriter) WriteHeader(header []string) error {
+	return nil
+}
+
+func (nw *ndjsonRowWriter) WriteRow(fields []string) error {
+	names := make(ColumnNames, len(fields))
+	mapped_row := make(map[string]interface{}, len(fields))
+	for i, field := range fields {
+		name := columnKey(nw.header, i)
+		names[i] = name
+		mapped_row[name] = field
+	}
+	_, err := nw.w.Write(append(jsonProject(mapped_row, names), '\n'))
+	return err
+}
+
+func (nw *ndjsonRowWriter) Flush() error {
+	return nil
+}
+
+// columnKey returns the header name of the ith column, or its position if the row has more columns than the header
+func columnKey(header []string, i int) string {
+	if i < len(header) {
+		return header[i]
+	}
+	return positionName(i)
+}
+
+type markdownRowWriter struct {
+	w io.Writer
+}
+
+func (mw *markdownRowWriter) WriteHeader(header []string) error {
+	if err := mw.WriteRow(header); err != nil {
+		return err
+	}
+	sep := make([]string, len(header))
+	for i := range sep {
+		sep[i] = "---"
+	}
+	_, err := io.WriteString(mw.w, "| "+strings.Join(sep, " | ")+" |\n")
+	return err
+}
+
+func (mw *markdownRowWriter) WriteRow(fields []string) error {
+	escaped := make([]string, len(fields))
+	for i, field := range fields {
+		escaped[i] = strings.ReplaceAll(field, "|", "\\|")
+	}
+	_, err := io.WriteString(mw.w, "| "+strings.Join(escaped, " | ")+" |\n")
+	return err
+}
+
+func (mw *markdownRowWriter) Flush() error {
+	return nil
+}
+
+// tableRowWriter keeps every row until Flush since the column widths are only known at the end
+type tableRowWriter struct {
+	w      io.Writer
+	header []string
+	rows   [][]string
+}
+
+func (tw *tableRowWriter) WriteHeader(header []string) error {
+	tw.header = header
+	return nil
+}
+
+func (tw *tableRowWriter) WriteRow(fields []string) error {
+	tw.rows = append(tw.rows, fields)
+	return nil
+}
+
+func (tw *tableRowWriter) Flush() error {
+	widths := []int{}
+	measure := func(fields []string) {
+		for i, field := range fields {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := utf8.RuneCountInString(field); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	measure(tw.header)
+	for _, fields := range tw.rows {
+		measure(fields)
+	}
+
+	line := func(fields []string) string {
+		cells := make([]string, len(fields))
+		for i, field := range fields {
+			cells[i] = field
+			// no need to pad the last column
+			if i < len(fields)-1 {
+				cells[i] += strings.Repeat(" ", widths[i]-utf8.RuneCountInString(field))
+			}
+		}
+		return strings.Join(cells, "  ") + "\n"
+	}
+
+	var b strings.Builder
+	if tw.header != nil {
+		b.WriteString(line(tw.header))
+		rule := make([]string, len(tw.header))
+		for i := range rule {
+			rule[i] = strings.Repeat("-", widths[i])
+		}
+		b.WriteString(strings.Join(rule, "  ") + "\n")
+	}
+	for _, fields := range tw.rows {
+		b.WriteString(line(fields))
+	}
+	tw.rows = nil
+	_, err := io.WriteString(tw.w, b.String())
+	return err
+}
+
+var format_names = map[string]OutputFormat{
+	"raw":      FormatRaw,
+	"csv":      FormatCSV,
+	"ndjson":   FormatNDJSON,
+	"markdown": FormatMarkdown,
+	"table":    FormatTable,
+}
+
+// ParseOutputFormat returns the OutputFormat by its name: raw, csv, ndjson, markdown or table
+func ParseOutputFormat(name string) (OutputFormat, error) {
+	format, ok := format_names[strings.ToLower(name)]
+	if !ok {
+		return FormatRaw, fmt.Errorf("unknown output format %q", name)
+	}
+	return format, nil
+}
@@ -5,25 +5,28 @@
 // and output it as the pipe writer. So you need to create an io.Pipe() first and
 // read the data stream from io.PipeReader
 //
-// Todo: Allow other char(s) than \n as line delimiter
+// The line delimiter can be changed with ReadCondition.Delimiter (or SetNewlineSeperator for every read)
+// and the column separator with ReadCondition.Separator
 package gostan
 
 import (
-	"bufio"
 	"bytes"
 	"context"
-	"fmt"
 	"io"
 	"os"
 	"regexp"
 	"time"
-
-	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 )
 
 // Default max buffer lenght is 8kb
 const MAX_LENGTH int64 = 8192
 
+// Largest buffer ReadCondition.AdaptiveBuffer grows to, 4mb
+const MAX_ADAPTIVE_LENGTH int64 = 4 << 20
+
+// line delimiter used when ReadCondition.Delimiter isn't set
+var newline_separator = []byte{'\n'}
+
 type ColumnNames []string
 
 type ReadCondition struct {
@@ -31,385 +34,281 @@ type ReadCondition struct {
 	StopIfRegexMatched    *regexp.Regexp
 	StopIfRegexNotMatched *regexp.Regexp // todo
 	RowLimit              int64
-	RegexFilter           *regexp.Regexp // todo
+	Skip                  int64          // discard this many rows before RowLimit starts counting, to page backward
+	RegexFilter           *regexp.Regexp // only output rows matching the regex
 	IncludeHeader         bool
-}
 
-func SetNewlineSeperator(sep []byte) {
-	// TODO
+	// Header tells whether the sources start with a header line, see HeaderMode
+	Header HeaderMode
+	// Columns names the columns of headerless sources, or renames them with HeaderFirstLine or HeaderDetect.
+	// Columns can also be named by their position, $1 for the first one, with or without header
+	Columns ColumnNames
+	// OnHeaderMismatch checks the header of every source against the one of the first source (the oldest)
+	OnHeaderMismatch HeaderMismatchPolicy
+
+	Delimiter []byte // line delimiter, newline by default
+	Separator byte   // column separator, comma by default
+
+	// BufferSize is the size of the windows local files are read in, MAX_LENGTH by default.
+	// Blobs take theirs as argument
+	BufferSize int64
+	// AdaptiveBuffer doubles the window whenever a line doesn't fit in it, and for blobs after every download
+	// since each one is a round trip, up to MAX_ADAPTIVE_LENGTH
+	AdaptiveBuffer bool
+	// Mmap maps local files in memory and scans them in place, rows point into the mapping rather than being copied.
+	// Files that can't be mapped are read as usual. A file must not be truncated while it's mapped
+	Mmap bool
+	// TrimNUL trims NUL bytes off both ends of the rows and skips the rows made of nothing else, for the padding
+	// of page blobs and preallocated log files. Otherwise NUL bytes are data like any other
+	TrimNUL bool
+	// Encoding of the sources, by default it's found from their BOM. UTF-16 is transcoded to UTF-8 and the BOM
+	// is left out of the first line, so it doesn't end up in the header
+	Encoding Encoding
+	// SkipBlankLines leaves out the lines that are empty or nothing but whitespace, like the \r of a \r\n line end.
+	// They don't reach the conditions, so they don't count toward Skip or RowLimit
+	SkipBlankLines bool
+	// ExactLastLine gives the last line of a source that doesn't end with the delimiter as it is. By default the
+	// delimiter is added to it so every row ends with one. The pipe output still ends every row with the delimiter
+	ExactLastLine bool
+
+	// JSONLines reads every line as a JSON object (NDJSON). Column names in the conditions
+	// are then field paths, nested fields are separated by dot e.g. "request.user.id"
+	JSONLines   bool
+	OnMalformed MalformedLinePolicy // what to do with a line that isn't valid JSON
+
+	// StopIf stops the read at the first row it returns true for, Keep only lets the rows it returns true for out.
+	// They are evaluated along with the conditions above, StopIf before the filters and Keep after them
+	StopIf func(Row) bool
+	Keep   func(Row) bool
+
+	// StopWhen and Where are the same as StopIf and Keep, as an expression that can come from a string. See ParseExpr
+	StopWhen *Expr
+	Where    *Expr
+
+	// DedupBy only lets the first row read of every key out, the latest one, for a current state view of an
+	// append only log. The key is the values of these columns. The older rows of a key are skipped even when
+	// its latest row doesn't make it through the filters
+	DedupBy ColumnNames
+	// DedupCacheSize bounds the keys DedupBy remembers, the least recently seen are forgotten first so an old
+	// row of a key read again afterwards comes out. All of them are kept if it's 0
+	DedupCacheSize int
+
+	SelectColumns ColumnNames               // only output these columns
+	ColumnFilter  map[string]*regexp.Regexp // only output rows whose column values match the regex
+
+	// CountForwardLines counts the lines of every source before reading it, so Record.ForwardLineNumber is known.
+	// It reads the whole source once more, forward
+	CountForwardLines bool
+
+	// MaxBytesScanned, MaxBytesEmitted and MaxDuration stop the read once it has read that many bytes of the sources
+	// (downloaded for blobs, the header and CountForwardLines reads aside), given out that many bytes of rows,
+	// or taken that long. The read then fails with ErrLimitReached so the rows are known to be partial.
+	// MaxBytesEmitted counts the rows as they are in the source, Record.Data, SchemaChange markers included:
+	// what's written out with SelectColumns or a Format can be bigger or smaller. 0 means no limit
+	MaxBytesScanned int64
+	MaxBytesEmitted int64
+	MaxDuration     time.Duration
+
+	// StartAt resumes a read from the Cursor returned by the previous one, instead of from the end
+	StartAt *Cursor
+
+	// Format converts the rows before they are written out, see OutputFormat.
+	// JSON Lines need SelectColumns for the CSV and table formats, otherwise they are written as they are
+	Format OutputFormat
 }
 
-// ReverseReadFiles reads local file(s) from EOF
-func ReverseReadFiles(out *io.PipeWriter, readCondition *ReadCondition, file_descriptors ...*os.File) {
-	delim_char := byte('\n')
-	// get header if needed
-	var headers [][]byte
-	var compare string
-	if readCondition.StopIfColValuesDiffer != nil || readCondition.IncludeHeader {
-		headers = GetFileHeader(file_descriptors[0], []byte{','})
+// needHeader tells whether the header of the source has to be read
+func (readCondition *ReadCondition) needHeader() bool {
+	switch readCondition.headerMode() {
+	case HeaderNone:
+		return false
+	case HeaderFirstLine:
+		return readCondition.Columns == nil || readCondition.OnHeaderMismatch != MismatchIgnore
+	case HeaderDetect:
+		return true
 	}
-	if readCondition.IncludeHeader {
-		out.Write(bytes.Join(headers, []byte{','}))
-		out.Write([]byte{delim_char})
+	return readCondition.OnHeaderMismatch != MismatchIgnore || readCondition.DedupBy != nil || readCondition.StopIfColValuesDiffer != nil || readCondition.IncludeHeader || readCondition.SelectColumns != nil ||
+		readCondition.ColumnFilter != nil || readCondition.Format != FormatRaw || readCondition.StopIf != nil ||
+		readCondition.Keep != nil || readCondition.StopWhen != nil || readCondition.Where != nil
+}
 
+// firstLineIsHeader tells whether the first line of the sources is the header and not a row.
+// With HeaderDetect it's only the case for the sources it's found in
+func (readCondition *ReadCondition) firstLineIsHeader() bool {
+	switch readCondition.headerMode() {
+	case HeaderNone:
+		return false
+	case HeaderFirstLine, HeaderDetect:
+		return true
 	}
-	var row_count int64 = 0
-	defer out.Close()
-	for i := range file_descriptors {
-		filefile_descriptor := file_descriptors[len(file_descriptors)-1-i]
-		defer filefile_descriptor.Close()
-
-		tempBuffer := make([]byte, 0)   // for temp buffer, it has to be dynamic depends on the size of remainder chars to be carry forward to the next buffer window
-		outputBuffer := make([]byte, 0) // use this buffer to store 1 row at a time to be piped out to the next processor
-
-		// find file offset position from the beginning of the file
-		file_size, _ := filefile_descriptor.Seek(0, io.SeekEnd) // or cursor position
-		file_pos := file_size
-
-		first_scan := true
-
-		// start reading file a buffer at a time
-		for {
-			readBuffer := [MAX_LENGTH]byte{}
-			readBufferLen := MAX_LENGTH
-			file_pos -= readBufferLen // on file, go back readBufferLen at a time
-
-			// read byte from current cursor (file_pos), this cursor moves back readBufferLen at a time
-			filefile_descriptor.ReadAt(readBuffer[:], file_pos)
-
-			// initial index always at the back of readbuffer
-			var string_start_index int64 = readBufferLen - 1
-			var string_end_index int64 = readBufferLen - 1
-			// var string_next_end_index int64 = readBufferLen - 1
-
-			var delim_index int64 = -1
-
-			if file_pos < 0 {
-				if file_pos < -readBufferLen {
-
-					// to include header, we assume the header is the last output. so only show the last output if there's no header
-					if !readCondition.IncludeHeader {
-						out.Write(tempBuffer[0:])
-					}
-					break
-				}
-				filefile_descriptor.ReadAt(readBuffer[:readBufferLen+file_pos], 0)
-
-			}
-
-			// scan backward. Remember, we're working in a read buffer now!
-			for i := range readBuffer {
-				scanner_index := (int(readBufferLen) - 1) - i
-				// skip if non char
-				if readBuffer[scanner_index] == '\x00' {
-					string_end_index--
-					continue
-				}
-				// append line sep if first read doesnt have it
-				if first_scan && readBuffer[scanner_index] != delim_char {
-					tempBuffer = append(tempBuffer, delim_char)
-				}
-				first_scan = false
-
-				//println("-->", string(readBuffer[scanner_index]))
-				// found delim
-				if readBuffer[scanner_index] == delim_char {
-					delim_index = int64(scanner_index)
-					string_start_index = delim_index + int64(1) //  \n[start from here][X][X][X]
-
-					// get the string behind this delim if within our readbuffer
-					if delim_index < readBufferLen-1 {
-						outputBuffer = append(outputBuffer, readBuffer[string_start_index:string_end_index+1]...)
-					}
-
-					// check if we have anything in temp buffer
-					if len(tempBuffer) > 0 {
-						// append to our output buffer
-						outputBuffer = append(outputBuffer, tempBuffer[0:]...)
-						tempBuffer = tempBuffer[:0]
-					}
-
-					// CONDITION 1
-					if readCondition.StopIfColValuesDiffer != nil {
-						// if we havent store the mapped string, store it. Else, compare
-						if len(compare) == 0 {
-							mapped_string := stringToMap(string(outputBuffer[0:]), headers)
-							for _, colName := range readCondition.StopIfColValuesDiffer {
-								if mapped_string[colName] != nil {
-									compare += mapped_string[colName].(string)
-								}
-							}
-						} else {
-							mapped_string := stringToMap(string(outputBuffer[0:]), headers)
-							compare2 := ""
-							for _, colName := range readCondition.StopIfColValuesDiffer {
-								if mapped_string[colName] != nil {
-									compare2 += mapped_string[colName].(string)
-								}
-							}
-							if compare2 != compare {
-								return
-							}
-						}
-
-					}
-
-					// CONDITION 2
-					if readCondition.StopIfRegexMatched != nil && readCondition.StopIfRegexMatched.MatchString(string(outputBuffer[0:])) {
-						return
-					}
-
-					// CONDITION 3
-					if readCondition.RowLimit > 0 && readCondition.RowLimit == row_count {
-						return
-					}
-
-					// write it out
-					out.Write(outputBuffer[0:])
-
-					outputBuffer = outputBuffer[:0]
-					string_end_index = delim_index
-					row_count++
-				}
-
-				// end of scan without finding anything
-				if scanner_index == 0 {
-					// save everything in temp buffer
-					t := make([]byte, len(readBuffer[0:string_end_index+1]))
-					copy(t, readBuffer[0:string_end_index+1])
-					tempBuffer = append(t, tempBuffer...)
-				}
+	// the header isn't a row when it's written out or columns are looked up by it. Hooks may not look at columns,
+	// the first line stays a row for them
+	return readCondition.IncludeHeader || readCondition.Format != FormatRaw || readCondition.OnHeaderMismatch != MismatchIgnore ||
+		readCondition.namesColumns()
+}
 
-			}
-			// empty the read buffer
-			readBuffer = [MAX_LENGTH]byte{}
+// namesColumns tells whether the conditions look columns up by header name, rather than by position
+func (readCondition *ReadCondition) namesColumns() bool {
+	if readCondition.StopIfColValuesDiffer.byName() || readCondition.SelectColumns.byName() || readCondition.DedupBy.byName() {
+		return true
+	}
+	for colName := range readCondition.ColumnFilter {
+		if !isPosition(colName) {
+			return true
 		}
 	}
+	return readCondition.Where.namesColumns() || readCondition.StopWhen.namesColumns()
 }
 
-// ReverseReadBlob reads file on Azure blob storage from EOF
-func ReverseReadBlob(out *io.PipeWriter, blobClient *azblob.BlockBlobClient, bufferSize int64, readCondition *ReadCondition) {
-
-	// check the file first see if it's not empty (or still empty)
-	prop, _ := blobClient.GetProperties(context.Background(), nil)
-
-	getSizeAttemptCount := 0
-	for *prop.ContentLength <= 0 && getSizeAttemptCount < 60 {
-		getSizeAttemptCount++
-		fmt.Println("File size cannot be 0. Retry attempt:", getSizeAttemptCount)
-		prop, _ = blobClient.GetProperties(context.Background(), nil)
-		time.Sleep(5 * time.Second)
+// delimiter returns the line delimiter of the read
+func (readCondition *ReadCondition) delimiter() []byte {
+	if len(readCondition.Delimiter) > 0 {
+		return readCondition.Delimiter
 	}
+	return newline_separator
+}
 
-	delim_char := byte('\n')
-	// get header if needed
-	var headers [][]byte
-	var compare string
-	if readCondition.StopIfColValuesDiffer != nil || readCondition.IncludeHeader {
-		headers = GetBlobHeader(blobClient, []byte{','}, 1024)
+// separator returns the column separator of the read
+func (readCondition *ReadCondition) separator() byte {
+	if readCondition.Separator != 0 {
+		return readCondition.Separator
 	}
-	if readCondition.IncludeHeader {
-		out.Write(bytes.Join(headers, []byte{','}))
-		out.Write([]byte{delim_char})
+	return ','
+}
 
+// SetNewlineSeperator sets the line delimiter for every read that doesn't set ReadCondition.Delimiter.
+// It's meant to be called once at start up, before any read
+func SetNewlineSeperator(sep []byte) {
+	if len(sep) == 0 {
+		sep = []byte{'\n'}
 	}
-	var row_count int64 = 0
-	defer out.Close()
-
-	var offset int64 = *prop.ContentLength - bufferSize
-	var cnt int64 = bufferSize
-
-	tempBuffer := make([]byte, 0)   // for temp buffer, it has to be dynamic depends on the size of remainder chars to be carry forward to the next buffer window
-	outputBuffer := make([]byte, 0) // use this buffer to store 1 row at a time to be piped out to the next processor
-	first_scan := true
+	newline_separator = append([]byte{}, sep...)
+}
 
-	for {
-		if offset < 0 {
-			if offset < -bufferSize {
-				if !readCondition.IncludeHeader {
-					out.Write(tempBuffer[0:])
-				}
-				break
-			}
-		}
-		t := make([]byte, bufferSize)
-		err := blobClient.DownloadToBuffer(context.TODO(), offset, cnt, t, azblob.DownloadOptions{
-			// Progress: func(bytesTransferred int64) {
-			// 	// fmt.Printf("Read %d bytes.\n", bytesTransferred)
-			// },
-		})
-		if err != nil {
-			fmt.Println(err.Error())
-		}
+// ReverseReadFiles reads local file(s) from EOF. The files are given oldest first, the last file is read first.
+// It returns the Cursor where the read stopped
+func ReverseReadFiles(out *io.PipeWriter, readCondition *ReadCondition, file_descriptors ...*os.File) Cursor {
+	for _, fd := range file_descriptors {
+		defer fd.Close()
+	}
+	return reverseRead(out, NewFileIterator(readCondition, file_descriptors...))
+}
 
-		// start read from buffer
+// ReverseReadBlob reads file on Azure blob storage from EOF.
+// It returns the Cursor where the read stopped
+func ReverseReadBlob(out *io.PipeWriter, blobClient BlobClient, bufferSize int64, readCondition *ReadCondition) Cursor {
+	return reverseRead(out, NewBlobIterator(blobClient, bufferSize, readCondition))
+}
 
-		reader := bytes.NewReader(t)
+// reverseRead writes out the rows of the iterator to the pipe
+func reverseRead(out *io.PipeWriter, it *RecordIterator) Cursor {
+	defer out.Close()
+	defer it.Close()
+	cursor, err := writeRows(context.Background(), out, it)
+	if err != nil {
+		out.CloseWithError(err)
+	}
+	return cursor
+}
 
-		readBufferLen := bufferSize
-		// initial index always at the back of readbuffer
-		var string_start_index int64 = readBufferLen - 1
-		var string_end_index int64 = readBufferLen - 1
-		// var string_next_end_index int64 = readBufferLen - 1
-		var delim_index int64 = -1
-		for {
-			readBuffer := make([]byte, bufferSize)
-			_, err := reader.Read(readBuffer)
-			if err != nil {
-				break
-			}
+// writeRows writes out the rows of the iterator in the format asked by the ReadCondition.
+// It stops once the context is done, even between rows that are filtered out, or a write fails
+// e.g. the other end of the pipe is closed
+func writeRows(ctx context.Context, w io.Writer, it *RecordIterator) (Cursor, error) {
+	if it.err != nil {
+		return it.cursor, it.err
+	}
 
-			for i := range readBuffer {
-				scanner_index := (int(readBufferLen) - 1) - i
-				// skip if non char
-				if readBuffer[scanner_index] == '\x00' {
-					string_end_index--
-					continue
-				}
-				// append line sep if first read doesnt have it
-				if first_scan && readBuffer[scanner_index] != delim_char {
-					tempBuffer = append(tempBuffer, delim_char)
-				}
-				first_scan = false
-
-				//println("-->", string(readBuffer[scanner_index]))
-				// found delim
-				if readBuffer[scanner_index] == delim_char {
-					delim_index = int64(scanner_index)
-					string_start_index = delim_index + int64(1) //  \n[start from here][X][X][X]
-
-					// get the string behind this delim if within our readbuffer
-					if delim_index < readBufferLen-1 {
-						outputBuffer = append(outputBuffer, readBuffer[string_start_index:string_end_index+1]...)
-					}
-
-					// check if we have anything in temp buffer
-					if len(tempBuffer) > 0 {
-						// append to our output buffer
-						outputBuffer = append(outputBuffer, tempBuffer[0:]...)
-						tempBuffer = tempBuffer[:0]
-					}
-					// CONDITION 1
-					if readCondition.StopIfColValuesDiffer != nil {
-						// if we havent store the mapped string, store it. Else, compare
-						if len(compare) == 0 {
-							mapped_string := stringToMap(string(outputBuffer[0:]), headers)
-							for _, colName := range readCondition.StopIfColValuesDiffer {
-								if mapped_string[colName] != nil {
-									compare += mapped_string[colName].(string)
-								}
-							}
-						} else {
-							mapped_string := stringToMap(string(outputBuffer[0:]), headers)
-							compare2 := ""
-							for _, colName := range readCondition.StopIfColValuesDiffer {
-								if mapped_string[colName] != nil {
-									compare2 += mapped_string[colName].(string)
-								}
-							}
-							if compare2 != compare {
-								println(compare2, "-", compare)
-								return
-							}
-						}
-
-					}
-
-					// CONDITION 2
-					if readCondition.StopIfRegexMatched != nil && readCondition.StopIfRegexMatched.MatchString(string(outputBuffer[0:])) {
-						return
-					}
-
-					// CONDITION 3
-					if readCondition.RowLimit > 0 && readCondition.RowLimit == row_count {
-						return
-					}
-
-					// write it out
-					out.Write(outputBuffer[0:])
-
-					outputBuffer = outputBuffer[:0]
-					string_end_index = delim_index
-					row_count++
-				}
-
-				// end of scan without finding anything
-				if scanner_index == 0 {
-					// save everything in temp buffer
-					t := make([]byte, len(readBuffer[0:string_end_index+1]))
-					copy(t, readBuffer[0:string_end_index+1])
-					tempBuffer = append(t, tempBuffer...)
-				}
-			}
+	it.setContext(ctx)
+	output := newRowOutput(w, it.checker)
+	for output.err == nil && it.Next() {
+		// write it out
+		if rec := it.Record(); rec.SchemaChange {
+			output.changeHeader(rec.Data)
+		} else {
+			output.writeRow(rec.Data)
 		}
-		offset -= bufferSize
 	}
+	output.flush()
+	if err := it.Err(); err != nil {
+		return it.Cursor(), err
+	}
+	return it.Cursor(), output.err
 }
 
-// GetBlobHeader reads the first line of the Azure blob
-func GetBlobHeader(blobClient *azblob.BlockBlobClient, delim []byte, bufferSize int64) [][]byte {
-
-	var offset int64 = 0
-	var cnt int64 = bufferSize
+// GetBlobHeader reads the first line of the Azure blob, it returns nil if the blob is empty or cannot be read
+func GetBlobHeader(blobClient BlobClient, delim []byte, bufferSize int64) [][]byte {
+	line, err := readFirstLine(newBlobSource(blobClient), EncodingAuto, []byte{'\n'}, bufferSize)
+	if err != nil || line == nil {
+		return nil
+	}
+	return bytes.Split(line, delim)
+}
 
-	tempBuffer := make([]byte, 0)   // for temp buffer, it has to be dynamic depends on the size of remainder chars to be carry forward to the next buffer window
-	outputBuffer := make([]byte, 0) // use this buffer to store 1 row at a time to be piped out to the next processor
+// GetFileHeader reads the first line of the file
+func GetFileHeader(fd *os.File, delim []byte) [][]byte {
+	line, err := readFirstLine(&fileSource{fd: fd}, EncodingAuto, []byte{'\n'}, 1024)
+	if err != nil || line == nil {
+		return nil
+	}
+	return bytes.Split(line, delim)
+}
 
-	for {
-		t := make([]byte, bufferSize)
-		err := blobClient.DownloadToBuffer(context.TODO(), offset, cnt, t, azblob.DownloadOptions{})
-		if err != nil {
-			fmt.Println(err.Error())
+// readFirstLine reads the source forward up to the first line delimiter, without BOM and decoded to UTF-8.
+// It returns nil if the source is empty
+func readFirstLine(src source, encoding Encoding, delim []byte, bufferSize int64) ([]byte, error) {
+	size, err := src.size()
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	if bufferSize <= 0 {
+		bufferSize = 1024
+	}
+	encoding, bom, err := detectEncoding(src, encoding)
+	if err != nil {
+		return nil, err
+	}
+	line_delim := delim
+	delim = encoding.encode(delim)
+	unit := encoding.unit()
+
+	line := []byte{}
+	buf := make([]byte, bufferSize)
+	for pos := bom; pos < size; pos += bufferSize {
+		n := bufferSize
+		if pos+n > size {
+			n = size - pos
 		}
-
-		reader := bytes.NewReader(t)
-
-		var delim_char byte = byte('\n')
-
-		for {
-			var delim_index int64 = -1
-			readBuffer := make([]byte, bufferSize)
-			_, err := reader.Read(readBuffer)
-			if err != nil {
+		if err := readFull(src, buf[:n], pos); err != nil {
+			return nil, err
+		}
+		// the delimiter may have started in the previous buffer
+		from := len(line) - len(delim) + 1
+		if from < 0 {
+			from = 0
+		}
+		line = append(line, buf[:n]...)
+		found := false
+		for from < len(line) {
+			i := bytes.Index(line[from:], delim)
+			if i < 0 {
 				break
 			}
-
-			for i := range readBuffer {
-				scanner_index := i
-
-				// found delim
-				if readBuffer[scanner_index] == delim_char {
-					delim_index = int64(scanner_index)
-					// check if we have anything in temp buffer
-					if len(tempBuffer) > 0 {
-						// append to our output buffer
-						outputBuffer = append(outputBuffer, tempBuffer[0:]...)
-					}
-					outputBuffer = append(outputBuffer, readBuffer[0:delim_index]...)
-					return bytes.Split(outputBuffer[0:], delim)
-
-				}
-
-				// end of scan without finding anything
-				if scanner_index == len(readBuffer)-1 {
-					// save everything in temp buffer
-					tempBuffer = append(tempBuffer, readBuffer[0:]...)
-				}
+			if (from+i)%unit == 0 {
+				line = line[:from+i]
+				found = true
+				break
 			}
-
+			from += i + 1
+		}
+		if found {
+			break
 		}
-		offset += bufferSize
 	}
-
-}
-
-// GetFileHeader reads the first line of the file
-func GetFileHeader(fd *os.File, delim []byte) [][]byte {
-	scanner := bufio.NewScanner(fd)
-	if scanner.Scan() {
-		b := scanner.Bytes()
-		return bytes.Split(b, delim)
+	line = encoding.decode(nil, line)
+	// a windows line ending leaves its \r behind
+	if bytes.Equal(line_delim, []byte{'\n'}) {
+		line = bytes.TrimSuffix(line, []byte{'\r'})
 	}
-	return nil
-
+	return line, nil
 }
@@ -0,0 +1,56 @@
+package gostan
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	content := "date,status,amount\n8/24/2022,OK,1\n8/25/2022,OK,2\n8/25/2022,FAILED,3.5\n8/25/2022,OK,x\n"
+	fd, err := os.CreateTemp(t.TempDir(), "aggregate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	fd.WriteString(content)
+
+	cases := []struct {
+		cond         *ReadCondition
+		opts         AggregateOptions
+		format       OutputFormat
+		control_text string
+	}{
+		// per status for the latest date, the group of the latest row first
+		{&ReadCondition{StopIfColValuesDiffer: ColumnNames{"date"}}, AggregateOptions{GroupBy: ColumnNames{"status"}, Sum: ColumnNames{"amount"}},
+			FormatCSV, "status,count,sum(amount)\nOK,2,2\nFAILED,1,3.5\n"},
+		{&ReadCondition{StopIfColValuesDiffer: ColumnNames{"date"}}, AggregateOptions{GroupBy: ColumnNames{"status"}, Sum: ColumnNames{"amount"}},
+			FormatNDJSON, `{"status":"OK","count":2,"sum(amount)":2}` + "\n" + `{"status":"FAILED","count":1,"sum(amount)":3.5}` + "\n"},
+		{&ReadCondition{}, AggregateOptions{GroupBy: ColumnNames{"date", "status"}},
+			FormatCSV, "date,status,count\n8/25/2022,OK,2\n8/25/2022,FAILED,1\n8/24/2022,OK,1\n"},
+		// all of them in one group
+		{&ReadCondition{Where: MustParseExpr(`status == "OK"`)}, AggregateOptions{Sum: ColumnNames{"amount"}},
+			FormatCSV, "count,sum(amount)\n3,3\n"},
+	}
+	for i, c := range cases {
+		for _, name := range []string{"file", "blob"} {
+			var result *AggregateResult
+			var err error
+			if name == "file" {
+				result, err = AggregateFiles(c.cond, c.opts, fd)
+			} else {
+				result, err = AggregateBlob(newFakeBlob([]byte(content)), 8, c.cond, c.opts)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var experiment_text bytes.Buffer
+			if err := result.Write(&experiment_text, c.format); err != nil {
+				t.Fatal(err)
+			}
+			if experiment_text.String() != c.control_text {
+				t.Errorf("case %d %s: got %q", i, name, experiment_text.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package gostan
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// fakeBlob is an in-memory BlobClient, with hooks to make its calls fail
+type fakeBlob struct {
+	url  string
+	data []byte
+
+	// failProperties and failDownload return the error the call fails with, or nil to let it through
+	failProperties func(call int) error
+	failDownload   func(offset int64, count int64) error
+
+	mu         sync.Mutex
+	properties int
+	downloads  [][2]int64 // offset and count of every download
+}
+
+var errFakeBlob = errors.New("fake blob failure")
+
+func newFakeBlob(data []byte) *fakeBlob {
+	return &fakeBlob{url: "https://fake.blob.core.windows.net/container/blob", data: data}
+}
+
+func (f *fakeBlob) GetProperties(ctx context.Context, options *azblob.BlobGetPropertiesOptions) (azblob.BlobGetPropertiesResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.properties++
+	resp := azblob.BlobGetPropertiesResponse{}
+	if err := ctx.Err(); err != nil {
+		return resp, err
+	}
+	if f.failProperties != nil {
+		if err := f.failProperties(f.properties); err != nil {
+			return resp, err
+		}
+	}
+	size := int64(len(f.data))
+	resp.ContentLength = &size
+	return resp, nil
+}
+
+func (f *fakeBlob) DownloadToBuffer(ctx context.Context, offset int64, count int64, b []byte, o azblob.DownloadOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.downloads = append(f.downloads, [2]int64{offset, count})
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if f.failDownload != nil {
+		if err := f.failDownload(offset, count); err != nil {
+			return err
+		}
+	}
+	if offset < 0 || offset+count > int64(len(f.data)) || int64(len(b)) < count {
+		return errors.New("range not satisfiable")
+	}
+	copy(b, f.data[offset:offset+count])
+	return nil
+}
+
+func (f *fakeBlob) URL() string {
+	return f.url
+}
@@ -0,0 +1,91 @@
+package gostan
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestReverseReadFilesResumeFromCursor(t *testing.T) {
+	pages := []string{`10,8/25/2022,Truelove
+9,8/25/2022,Druery
+8,8/25/2022,Laux
+7,8/25/2022,Arghent
+6,8/25/2022,Monketon
+5,8/25/2022,Tabourin
+4,8/25/2022,Dowty
+`, `3,8/25/2022,Canet
+2,8/25/2022,Withur
+1,8/25/2022,Schoenleiter
+10,8/24/2022,Dagon
+9,8/24/2022,Welfare
+8,8/24/2022,Tunsley
+7,8/24/2022,Jacmard
+`, `6,8/24/2022,Cutler
+5,8/24/2022,Chaucer
+4,8/24/2022,Bellord
+3,8/24/2022,Darinton
+2,8/24/2022,Limeburn
+1,8/24/2022,Rainger
+`}
+
+	var start *Cursor
+	for i, control_text := range pages {
+		fd1, err := os.Open("./mockfile1")
+		if err != nil {
+			panic(fmt.Sprintf("open file error:%s", err.Error()))
+		}
+		fd2, err := os.Open("./mockfile2")
+		if err != nil {
+			panic(fmt.Sprintf("open file error:%s", err.Error()))
+		}
+
+		r, w := io.Pipe()
+		done := make(chan Cursor, 1)
+		cond := &ReadCondition{IncludeHeader: true, RowLimit: 7, StartAt: start}
+		go func() {
+			done <- ReverseReadFiles(w, cond, fd1, fd2)
+		}()
+
+		b, _ := io.ReadAll(r)
+		cursor := <-done
+		if string(b) != "id,date,name\n"+control_text {
+			t.Errorf("page %d: got\n%s", i, string(b))
+		}
+
+		// hand the cursor out as token and take it back in, like a client would
+		next, err := ParseCursor(cursor.String())
+		if err != nil || next != cursor {
+			t.Fatalf("page %d: cursor %v doesn't survive the round trip: %v", i, cursor, err)
+		}
+		start = &next
+	}
+
+	if !start.AtStart() {
+		t.Errorf("cursor %v should be at the start after the last page", start)
+	}
+}
+
+func TestParseCursorInvalid(t *testing.T) {
+	for _, token := range []string{"", "abc", "1", "-1:5", "0:-2"} {
+		if _, err := ParseCursor(token); err == nil {
+			t.Errorf("%q should not parse", token)
+		}
+	}
+}
+
+func TestStartAtInvalid(t *testing.T) {
+	fd, err := os.Open("./mockfile1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	for _, cursor := range []Cursor{{Source: -1, Offset: 5}, {Source: 0, Offset: -1}, {Source: 1, Offset: 0}} {
+		it := NewFileIterator(&ReadCondition{StartAt: &cursor}, fd)
+		if it.Next() || it.Err() == nil {
+			t.Errorf("%v should be rejected", cursor)
+		}
+	}
+}
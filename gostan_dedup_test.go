@@ -0,0 +1,69 @@
+package gostan
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+)
+
+func TestDedupBy(t *testing.T) {
+	content := "id,status\n1,new\n2,new\n1,done\n3,new\n2,deleted\n1,closed\n"
+	fd, err := os.CreateTemp(t.TempDir(), "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	fd.WriteString(content)
+
+	cases := []struct {
+		cond         *ReadCondition
+		control_text string
+	}{
+		{&ReadCondition{IncludeHeader: true, DedupBy: ColumnNames{"id"}}, "id,status\n1,closed\n2,deleted\n3,new\n"},
+		// the header is left out
+		{&ReadCondition{Header: HeaderFirstLine, DedupBy: ColumnNames{"id"}, RowLimit: 2}, "1,closed\n2,deleted\n"},
+		// 2 is deleted, its older rows don't come back
+		{&ReadCondition{Header: HeaderFirstLine, DedupBy: ColumnNames{"id"}, Where: MustParseExpr(`status != "deleted"`)}, "1,closed\n3,new\n"},
+		{&ReadCondition{Header: HeaderFirstLine, DedupBy: ColumnNames{"id", "status"}, Where: MustParseExpr(`status == "new"`)}, "3,new\n2,new\n1,new\n"},
+		// only the last key is remembered
+		{&ReadCondition{Header: HeaderFirstLine, DedupBy: ColumnNames{"id"}, DedupCacheSize: 1}, "1,closed\n2,deleted\n3,new\n1,done\n2,new\n1,new\n"},
+		{&ReadCondition{Header: HeaderFirstLine, DedupBy: ColumnNames{"id"}, DedupCacheSize: 3}, "1,closed\n2,deleted\n3,new\n"},
+		{&ReadCondition{DedupBy: ColumnNames{"$1"}, Header: HeaderNone}, "1,closed\n2,deleted\n3,new\nid,status\n"},
+	}
+	for i, c := range cases {
+		for _, it := range []*RecordIterator{
+			NewFileIterator(c.cond, fd),
+			NewBlobIterator(newFakeBlob([]byte(content)), 8, c.cond),
+		} {
+			var experiment_text bytes.Buffer
+			if _, err := writeRows(context.Background(), &experiment_text, it); err != nil {
+				t.Fatal(err)
+			}
+			if experiment_text.String() != c.control_text {
+				t.Errorf("case %d %s: got %q", i, it.sources[0].name(), experiment_text.String())
+			}
+		}
+	}
+}
+
+func TestKeyCache(t *testing.T) {
+	c := newKeyCache(2)
+	for i, k := range []string{"a", "b", "a", "c", "a", "b"} {
+		got := c.seen(k)
+		// b is forgotten when c comes in, a was seen after it
+		want := i == 2 || i == 4
+		if got != want {
+			t.Errorf("%d %s: got %v", i, k, got)
+		}
+	}
+	if len(c.keys) != 2 || c.order.Len() != 2 {
+		t.Errorf("%d keys kept", len(c.keys))
+	}
+}
+
+func TestJoinKey(t *testing.T) {
+	if joinKey([]string{"a\x00b", "c"}) == joinKey([]string{"a", "b\x00c"}) || joinKey([]string{"1:a", ""}) == joinKey([]string{"1", "a"}) {
+		t.Error("keys of different values collide")
+	}
+}
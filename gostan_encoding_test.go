@@ -0,0 +1,77 @@
+package gostan
+
+import (
+	"os"
+	"testing"
+)
+
+func TestReverseReadFilesEncoding(t *testing.T) {
+	// the third name has "\n" as UTF-16 across two of its characters, which is not a line break
+	content := "id,date,name\n1,8/24/2022,Rainger\n2,8/25/2022,Cutler\n3,8/25/2022,AੁĀੁ\n"
+	control_text := "3,8/25/2022,AੁĀੁ\n2,8/25/2022,Cutler\n"
+
+	files := map[string][]byte{
+		"utf-8":     []byte(content),
+		"utf-8 bom": append(append([]byte{}, bom_utf8...), content...),
+		"utf-16le":  append(append([]byte{}, bom_utf16le...), EncodingUTF16LE.encode([]byte(content))...),
+		"utf-16be":  append(append([]byte{}, bom_utf16be...), EncodingUTF16BE.encode([]byte(content))...),
+		"no bom le": EncodingUTF16LE.encode([]byte(content)),
+		"no bom be": EncodingUTF16BE.encode([]byte(content)),
+	}
+	declared := map[string]Encoding{"no bom le": EncodingUTF16LE, "no bom be": EncodingUTF16BE}
+	for name, data := range files {
+		fd, err := os.CreateTemp(t.TempDir(), "encoding")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fd.Close()
+		fd.Write(data)
+
+		for _, cond := range []*ReadCondition{
+			{BufferSize: 5},
+			{BufferSize: 64, Mmap: true},
+		} {
+			cond.IncludeHeader = true
+			cond.Encoding = declared[name]
+			// the header's first column is "id", not the BOM followed by id
+			cond.StopIfColValuesDiffer = ColumnNames{"date"}
+			cond.Where = MustParseExpr("id >= 2")
+
+			experiment_text := ""
+			cursor, err := ReverseReadFilesFunc(cond, func(line []byte) (bool, error) {
+				experiment_text += string(line)
+				return false, nil
+			}, fd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if experiment_text != control_text {
+				t.Errorf("%s mmap %v: got %q", name, cond.Mmap, experiment_text)
+			}
+			if cursor.AtStart() {
+				t.Errorf("%s: got cursor %v", name, cursor)
+			}
+		}
+
+		// the lines are counted forward the same way, whatever the buffer cuts
+		for _, bufferSize := range []int64{3, 5, 64} {
+			it := NewFileIterator(&ReadCondition{Encoding: declared[name], CountForwardLines: true, BufferSize: bufferSize}, fd)
+			records := 0
+			for it.Next() {
+				records++
+				if rec := it.Record(); rec.LineNumber+rec.ForwardLineNumber != 5 {
+					t.Errorf("%s buffer %d: %q is line %d from the end and %d from the start", name, bufferSize, rec.Data, rec.LineNumber, rec.ForwardLineNumber)
+				}
+			}
+			if it.Err() != nil || records != 4 {
+				t.Errorf("%s buffer %d: got %d records, %v", name, bufferSize, records, it.Err())
+			}
+		}
+
+		// the header on its own
+		header := GetFileHeader(fd, []byte{','})
+		if name[:2] != "no" && (len(header) != 3 || string(header[0]) != "id") {
+			t.Errorf("%s: got header %q", name, header)
+		}
+	}
+}
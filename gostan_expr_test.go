@@ -0,0 +1,52 @@
+package gostan
+
+import "testing"
+
+func TestParseExpr(t *testing.T) {
+	c := newRowChecker(&ReadCondition{}, [][]byte{[]byte("id"), []byte("date"), []byte("name"), []byte("first name")})
+	row := c.newRow([]byte("9,8/25/2022,Druery,Ann"))
+
+	cases := map[string]bool{
+		`id == 9`:                              true,
+		`id > 10`:                              false,
+		`id < 10`:                              true, // numbers, "9" > "10" as strings
+		`date >= "8/25/2022"`:                  true,
+		`date < "2022-08-26"`:                  true,
+		`name == 'Druery' && id != 9`:          false,
+		`name == "Druery" || id != 9`:          true,
+		`true_col == "" and not (id == 8)`:     true,
+		`!name =~ "^D" || id == 9 && id == 8`:  false,
+		`(name !~ "^D" || id == 9) && id == 9`: true,
+		"`first name` == Ann":                  false, // Ann is a column, not a value
+		"`first name` == \"Ann\"":              true,
+	}
+	for s, want := range cases {
+		e, err := ParseExpr(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+			continue
+		}
+		if got := e.Eval(row); got != want {
+			t.Errorf("%s: got %v", s, got)
+		}
+	}
+
+	// NaN and Inf are words, not numbers
+	row = c.newRow([]byte("NaN,Inf,Nan,x"))
+	for s, want := range map[string]bool{`id == 0`: false, `id != 1`: true, `date > 1000000 && date < 2000000`: false, `name == "Nan"`: true} {
+		if got := MustParseExpr(s).Eval(row); got != want {
+			t.Errorf("%s: got %v", s, got)
+		}
+	}
+
+	// slashed dates are month first only
+	if compareValues("12/1/2022", "1/13/2022") != 1 || compareValues("12/1/2022", "13/1/2022") != -1 {
+		t.Error("12/1/2022 is December 1st")
+	}
+
+	for _, s := range []string{``, `id ==`, `id 9`, `(id == 9`, `id == 9)`, `name =~ "("`, `name =~ id`, `"a`, `id @ 9`} {
+		if _, err := ParseExpr(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
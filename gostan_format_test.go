@@ -0,0 +1,73 @@
+package gostan
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestReverseReadFilesFormat(t *testing.T) {
+	tests := []struct {
+		name         string
+		cond         *ReadCondition
+		control_text string
+	}{
+		{"ndjson", &ReadCondition{Format: FormatNDJSON, RowLimit: 2}, `{"id":"10","date":"8/24/2022","name":"Dagon"}
+{"id":"9","date":"8/24/2022","name":"Welfare"}
+`},
+		{"csv", &ReadCondition{Format: FormatCSV, IncludeHeader: true, RowLimit: 2, SelectColumns: ColumnNames{"name", "id"}}, `name,id
+Dagon,10
+Welfare,9
+`},
+		{"markdown", &ReadCondition{Format: FormatMarkdown, RowLimit: 2}, `| id | date | name |
+| --- | --- | --- |
+| 10 | 8/24/2022 | Dagon |
+| 9 | 8/24/2022 | Welfare |
+`},
+		{"table", &ReadCondition{Format: FormatTable, RowLimit: 2}, `id  date       name
+--  ---------  -------
+10  8/24/2022  Dagon
+9   8/24/2022  Welfare
+`},
+		// the header is the first line of the file and must not come out as a row
+		{"csv no limit", &ReadCondition{Format: FormatCSV, SelectColumns: ColumnNames{"id"}}, "10\n9\n8\n7\n6\n5\n4\n3\n2\n1\n"},
+	}
+
+	for _, test := range tests {
+		fd, err := os.Open("./mockfile1")
+		if err != nil {
+			panic(fmt.Sprintf("open file error:%s", err.Error()))
+		}
+
+		r, w := io.Pipe()
+		go ReverseReadFiles(w, test.cond, fd)
+
+		b, _ := io.ReadAll(r)
+		if string(b) != test.control_text {
+			t.Errorf("%s: got\n%s", test.name, string(b))
+		}
+	}
+}
+
+func TestRowWriterQuoting(t *testing.T) {
+	header := []string{"id", "comment"}
+	row := SplitRow([]byte(`1,"says ""hi"", then leaves"` + "\n"))
+
+	var buf bytes.Buffer
+	csvWriter := NewRowWriter(&buf, FormatCSV, header)
+	csvWriter.WriteHeader(header)
+	csvWriter.WriteRow(row)
+	csvWriter.Flush()
+	if buf.String() != "id,comment\n1,\"says \"\"hi\"\", then leaves\"\n" {
+		t.Errorf("csv: got %q", buf.String())
+	}
+
+	buf.Reset()
+	ndjsonWriter := NewRowWriter(&buf, FormatNDJSON, header)
+	ndjsonWriter.WriteRow(row)
+	if buf.String() != `{"id":"1","comment":"says \"hi\", then leaves"}`+"\n" {
+		t.Errorf("ndjson: got %q", buf.String())
+	}
+}
@@ -0,0 +1,90 @@
+package gostan
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// fuzz_delimiters can't overlap with themselves, otherwise splitting forward and backward legitimately differ
+var fuzz_delimiters = []string{"\n", "\r\n", "|", "ab", "\x00"}
+
+// forwardReversed is what a reverse read is expected to give: the lines split forward, in reverse order,
+// the last one with the delimiter added if it doesn't have it
+func forwardReversed(data []byte, delim []byte) []string {
+	data = bytes.TrimPrefix(data, bom_utf8)
+	lines := []string{}
+	for _, line := range bytes.SplitAfter(data, delim) {
+		if len(line) == 0 {
+			continue
+		}
+		if !bytes.HasSuffix(line, delim) {
+			line = append(line, delim...)
+		}
+		lines = append(lines, string(line))
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines
+}
+
+func FuzzReverseRead(f *testing.F) {
+	long := strings.Repeat("x", int(MAX_LENGTH))
+	f.Add([]byte("id,date,name\n1,8/24/2022,Rainger\n2,8/24/2022,Limeburn\n"), uint8(0), uint16(8))
+	f.Add([]byte("no trailing newline\n\n\nlast"), uint8(0), uint16(3))
+	f.Add([]byte("\n\n\n"), uint8(0), uint16(1))
+	f.Add([]byte("a\r\nb\rc\n\r\nd\r"), uint8(1), uint16(2))
+	f.Add([]byte("nul\x00inside\x00\x00|x|"), uint8(2), uint16(5))
+	f.Add([]byte("aab"+"bab"+"ab"), uint8(3), uint16(1))
+	f.Add([]byte("\x00\x00a\x00"), uint8(4), uint16(2))
+	f.Add([]byte(long+"\n"+long[1:]+"\n"+long+"x\n"), uint8(0), uint16(MAX_LENGTH))
+	f.Add([]byte(long[2:]+"\r\n"+long), uint8(1), uint16(MAX_LENGTH-1))
+	f.Add([]byte(""), uint8(0), uint16(0))
+
+	f.Fuzz(func(t *testing.T, data []byte, delim_choice uint8, buffer uint16) {
+		delim := []byte(fuzz_delimiters[int(delim_choice)%len(fuzz_delimiters)])
+		want := forwardReversed(data, delim)
+
+		fd, err := os.CreateTemp(t.TempDir(), "fuzz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fd.Close()
+		if _, err := fd.Write(data); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, bufferSize := range []int64{int64(buffer), 1, 2, 3, MAX_LENGTH} {
+			for _, adaptive := range []bool{false, true} {
+				newCondition := func() *ReadCondition {
+					return &ReadCondition{Delimiter: delim, Encoding: EncodingUTF8, BufferSize: bufferSize, AdaptiveBuffer: adaptive}
+				}
+				mmapCondition := newCondition()
+				mmapCondition.Mmap = true
+				iterators := map[string]*RecordIterator{
+					"file": NewFileIterator(newCondition(), fd),
+					"mmap": NewFileIterator(mmapCondition, fd),
+				}
+				if len(data) > 0 {
+					// an empty blob is waited for, that's tested on its own
+					iterators["blob"] = NewBlobIterator(newFakeBlob(data), bufferSize, newCondition())
+				}
+
+				for name, it := range iterators {
+					got := []string{}
+					for it.Next() {
+						got = append(got, string(it.Record().Data))
+					}
+					if it.Err() != nil {
+						t.Fatalf("%s buffer %d adaptive %v: %v", name, bufferSize, adaptive, it.Err())
+					}
+					if strings.Join(got, "") != strings.Join(want, "") || len(got) != len(want) {
+						t.Fatalf("%s buffer %d adaptive %v delimiter %q\ngot  %q\nwant %q", name, bufferSize, adaptive, delim, got, want)
+					}
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,200 @@
+package gostan
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+func TestTailHandler(t *testing.T) {
+	server := httptest.NewServer(&TailHandler{Dirs: []string{"."}})
+	defer server.Close()
+
+	control_text := `{"id":"10","date":"8/25/2022","name":"Truelove"}
+{"id":"9","date":"8/25/2022","name":"Druery"}
+`
+	query := url.Values{"source": {"mockfile1", "mockfile2"}, "n": {"2"}, "format": {"ndjson"}}
+	resp, err := http.Get(server.URL + "?" + query.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(b) != control_text {
+		t.Fatalf("got %d %q", resp.StatusCode, string(b))
+	}
+	if resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("unexpected content type %s", resp.Header.Get("Content-Type"))
+	}
+
+	// next page from the cursor given in the trailer
+	query.Set("cursor", resp.Trailer.Get(CursorTrailer))
+	query.Set("format", "csv")
+	resp, err = http.Get(server.URL + "?" + query.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "8,8/25/2022,Laux\n7,8/25/2022,Arghent\n" {
+		t.Errorf("second page: got %q", string(b))
+	}
+
+	// partial page up to the limit
+	query = url.Values{"source": {"mockfile2"}, "max_bytes_emitted": {"50"}}
+	resp, err = http.Get(server.URL + "?" + query.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "10,8/25/2022,Truelove\n9,8/25/2022,Druery\n" || resp.Trailer.Get(LimitTrailer) != "MaxBytesEmitted" {
+		t.Errorf("limited: got %q, %q", string(b), resp.Trailer.Get(LimitTrailer))
+	}
+}
+
+func TestTailHandlerRejects(t *testing.T) {
+	server := httptest.NewServer(&TailHandler{Dirs: []string{"./cmd"}})
+	defer server.Close()
+
+	tests := []struct {
+		query  url.Values
+		status int
+	}{
+		{url.Values{}, http.StatusBadRequest},
+		{url.Values{"source": {"../mockfile1"}}, http.StatusForbidden},
+		{url.Values{"source": {"/etc/passwd"}}, http.StatusForbidden},
+		{url.Values{"source": {"az://account/container/blob"}}, http.StatusForbidden},
+		{url.Values{"source": {"gostan/missing.go"}}, http.StatusNotFound},
+		{url.Values{"source": {"../missing"}}, http.StatusForbidden},
+		{url.Values{"source": {"gostan/main.go"}, "n": {"x"}}, http.StatusBadRequest},
+		{url.Values{"source": {"gostan/main.go"}, "stop_regex": {"("}}, http.StatusBadRequest},
+		{url.Values{"source": {"gostan/main.go"}, "cursor": {"nope"}}, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		resp, err := http.Get(server.URL + "?" + test.query.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("%v: got %d, expected %d", test.query, resp.StatusCode, test.status)
+		}
+	}
+}
+
+func TestTailHandlerClientGone(t *testing.T) {
+	// a blob of a GiB served by a fake storage account, only its last line passes the filter
+	const size = 1 << 30
+	var downloads int64
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-version", "2020-10-02")
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(size))
+			return
+		}
+		atomic.AddInt64(&downloads, 1)
+		var from, to int64
+		fmt.Sscanf(r.Header.Get("x-ms-range"), "bytes=%d-%d", &from, &to)
+		body := make([]byte, to-from+1)
+		for i := range body {
+			body[i] = "x\n"[(from+int64(i))%2]
+		}
+		if to == size-1 {
+			copy(body[len(body)-6:], "\nkeep\n")
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", from, to, size))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(body)
+	}))
+	defer storage.Close()
+	containerClient, err := azblob.NewContainerClientWithNoCredential(storage.URL+"/container", &azblob.ClientOptions{
+		Retry: policy.RetryOptions{MaxRetries: -1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	handler := &TailHandler{Containers: map[string]*azblob.ContainerClient{"account/container": containerClient}, BufferSize: 1024}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+		close(done)
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?source=az://account/container/blob&filter=keep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// take the row and hang up, the handler must return rather than keep on downloading the blob
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != "keep\n" {
+		t.Fatalf("got %q %v", line, err)
+	}
+	resp.Body.Close()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("the handler is still reading after %d downloads", atomic.LoadInt64(&downloads))
+	}
+}
+
+func TestTailHandlerErrors(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a"), []byte("id,date,name\n1,8/24/2022,Rainger\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "b"), []byte("id,day,name\n2,8/25/2022,Limeburn\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "c"), []byte("id,date,name\n3,8/26/2022,Dagon\n"), 0o644)
+	server := httptest.NewServer(&TailHandler{Dirs: []string{dir}})
+	defer server.Close()
+
+	// the newest source has another header, nothing is sent
+	query := url.Values{"source": {"a", "b"}, "header_mismatch": {"fail"}}
+	resp, err := http.Get(server.URL + "?" + query.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("got %d", resp.StatusCode)
+	}
+
+	// not found, without telling where it was looked for
+	resp, err = http.Get(server.URL + "?source=d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound || strings.Contains(string(b), dir) {
+		t.Errorf("got %d %q", resp.StatusCode, string(b))
+	}
+
+	// an older one has, the rows so far are sent and the error comes in the trailer
+	query.Set("source", "a")
+	query.Add("source", "b")
+	query.Add("source", "c")
+	resp, err = http.Get(server.URL + "?" + query.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(b) != "3,8/26/2022,Dagon\n" || !strings.Contains(resp.Trailer.Get(ErrorTrailer), "header mismatch") {
+		t.Errorf("got %d %q, %q", resp.StatusCode, string(b), resp.Trailer.Get(ErrorTrailer))
+	}
+}
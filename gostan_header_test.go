@@ -0,0 +1,224 @@
+package gostan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestHeaderless(t *testing.T) {
+	headerless, err := os.CreateTemp(t.TempDir(), "headerless")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer headerless.Close()
+	headerless.WriteString("1,8/24/2022,Rainger\n2,8/25/2022,Limeburn\n3,8/25/2022,Dagon\n")
+	headered, err := os.Open("./mockfile1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer headered.Close()
+
+	cases := []struct {
+		fd           *os.File
+		cond         *ReadCondition
+		control_text string
+	}{
+		// the first line is a row, not the header
+		{headerless, &ReadCondition{Columns: ColumnNames{"id", "date", "name"}, StopIfColValuesDiffer: ColumnNames{"date"}},
+			"3,8/25/2022,Dagon\n2,8/25/2022,Limeburn\n"},
+		{headerless, &ReadCondition{Columns: ColumnNames{"id", "date", "name"}, IncludeHeader: true, Where: MustParseExpr(`id < 2`)},
+			"id,date,name\n1,8/24/2022,Rainger\n"},
+		// by position, nothing to write as header
+		{headerless, &ReadCondition{Header: HeaderNone, IncludeHeader: true, Where: MustParseExpr(`$3 =~ "^R" || $1 == 3`)},
+			"3,8/25/2022,Dagon\n1,8/24/2022,Rainger\n"},
+		{headerless, &ReadCondition{Header: HeaderNone, SelectColumns: ColumnNames{"$3", "$1"}, RowLimit: 2},
+			"Dagon,3\nLimeburn,2\n"},
+		{headerless, &ReadCondition{Header: HeaderNone, Format: FormatNDJSON, RowLimit: 1},
+			`{"$1":"3","$2":"8/25/2022","$3":"Dagon"}` + "\n"},
+		{headerless, &ReadCondition{Header: HeaderDetect, IncludeHeader: true},
+			"3,8/25/2022,Dagon\n2,8/25/2022,Limeburn\n1,8/24/2022,Rainger\n"},
+		{headered, &ReadCondition{Header: HeaderDetect, IncludeHeader: true, RowLimit: 1},
+			"id,date,name\n10,8/24/2022,Dagon\n"},
+		// the header can be renamed, and is still not a row without IncludeHeader
+		{headered, &ReadCondition{Header: HeaderFirstLine, Columns: ColumnNames{"n", "d", "who"}, Where: MustParseExpr(`n <= 1`)},
+			"1,8/24/2022,Rainger\n"},
+		{headered, &ReadCondition{Header: HeaderFirstLine, Columns: ColumnNames{"n", "d", "who"}, IncludeHeader: true, RowLimit: 1},
+			"n,d,who\n10,8/24/2022,Dagon\n"},
+		// by position along with the header
+		{headered, &ReadCondition{IncludeHeader: true, SelectColumns: ColumnNames{"name", "$1"}, RowLimit: 1},
+			"name,$1\nDagon,10\n"},
+	}
+	for i, c := range cases {
+		var experiment_text bytes.Buffer
+		if _, err := writeRows(context.Background(), &experiment_text, NewFileIterator(c.cond, c.fd)); err != nil {
+			t.Fatal(err)
+		}
+		if experiment_text.String() != c.control_text {
+			t.Errorf("case %d: got %q", i, experiment_text.String())
+		}
+	}
+}
+
+func TestLooksLikeHeader(t *testing.T) {
+	cases := map[string]bool{
+		"id,date,name":        true,
+		"first name,LastName": true,
+		"NaN,Infinity":        true,
+		"1,8/24/2022,Rainger": false,
+		"Rainger,2022-08-24":  false,
+		"id,,name":            false,
+		"id,id":               false,
+		"":                    false,
+	}
+	for line, want := range cases {
+		if got := looksLikeHeader(bytes.Split([]byte(line), []byte{','})); got != want {
+			t.Errorf("%q: got %v", line, got)
+		}
+	}
+}
+
+func TestHeaderPerSource(t *testing.T) {
+	mockfile1, _ := os.ReadFile("./mockfile1")
+	mockfile2, _ := os.ReadFile("./mockfile2")
+	headerless := []byte("11,8/26/2022,Nobody\n")
+	renamed := []byte("id,day,name\n1,8/27/2022,Someone\n")
+
+	cases := []struct {
+		cond       *ReadCondition
+		data       [][]byte
+		control    []string // rows, first and last
+		count      int
+		mismatched bool
+	}{
+		// neither header is a row
+		{&ReadCondition{Header: HeaderFirstLine}, [][]byte{mockfile1, mockfile2},
+			[]string{"10,8/25/2022,Truelove\n", "1,8/24/2022,Rainger\n"}, 20, false},
+		// read as column names, so not a row either
+		{&ReadCondition{SelectColumns: ColumnNames{"name"}}, [][]byte{mockfile1, mockfile2},
+			[]string{"10,8/25/2022,Truelove\n", "1,8/24/2022,Rainger\n"}, 20, false},
+		{&ReadCondition{Where: MustParseExpr(`id != 5`)}, [][]byte{mockfile1, mockfile2},
+			[]string{"10,8/25/2022,Truelove\n", "1,8/24/2022,Rainger\n"}, 18, false},
+		// only the first line that looks like a header is one
+		{&ReadCondition{Header: HeaderDetect}, [][]byte{mockfile1, headerless},
+			[]string{"11,8/26/2022,Nobody\n", "1,8/24/2022,Rainger\n"}, 11, false},
+		// named by the header of the newer one
+		{&ReadCondition{Header: HeaderDetect, Where: MustParseExpr(`id > 9`)}, [][]byte{headerless, mockfile1},
+			[]string{"10,8/24/2022,Dagon\n", "11,8/26/2022,Nobody\n"}, 2, false},
+		{&ReadCondition{OnHeaderMismatch: MismatchFail}, [][]byte{mockfile1, mockfile2},
+			[]string{"10,8/25/2022,Truelove\n", "1,8/24/2022,Rainger\n"}, 20, false},
+		// fails before the rows of the other header
+		{&ReadCondition{OnHeaderMismatch: MismatchFail}, [][]byte{mockfile1, renamed}, nil, 0, true},
+		{&ReadCondition{OnHeaderMismatch: MismatchFail, Header: HeaderDetect}, [][]byte{mockfile1, headerless}, nil, 0, true},
+		{&ReadCondition{OnHeaderMismatch: MismatchFail}, [][]byte{mockfile1, mockfile2, renamed, mockfile1}, nil, 10, true},
+	}
+	for i, c := range cases {
+		files := make([]source, len(c.data))
+		blobs := make([]source, len(c.data))
+		for j, data := range c.data {
+			fd, err := os.CreateTemp(t.TempDir(), "source")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fd.Close()
+			fd.Write(data)
+			files[j] = &fileSource{fd: fd}
+			blobs[j] = newBlobSource(newFakeBlob(data))
+		}
+
+		for name, sources := range map[string][]source{"files": files, "blobs": blobs} {
+			it := newRecordIterator(c.cond, 16, sources...)
+			experiment_rows := []string{}
+			for it.Next() {
+				experiment_rows = append(experiment_rows, string(it.Record().Data))
+			}
+			if c.mismatched {
+				if !errors.Is(it.Err(), ErrHeaderMismatch) || len(experiment_rows) != c.count {
+					t.Errorf("case %d %s: got %d rows and error %v", i, name, len(experiment_rows), it.Err())
+				}
+				continue
+			}
+			if it.Err() != nil {
+				t.Fatalf("case %d %s: %v", i, name, it.Err())
+			}
+			if len(experiment_rows) != c.count || experiment_rows[0] != c.control[0] || experiment_rows[c.count-1] != c.control[1] {
+				t.Errorf("case %d %s: got %q", i, name, experiment_rows)
+			}
+		}
+	}
+}
+
+func TestSchemaDrift(t *testing.T) {
+	older := []byte("id,date,name\n1,8/24/2022,Rainger\n")
+	newer := []byte("id,date,status,name\n2,8/25/2022,OK,Limeburn\n3,8/25/2022,FAILED,Dagon\n")
+
+	cases := []struct {
+		cond         *ReadCondition
+		control_text string
+	}{
+		// by name into the columns of both, the older rows have no status
+		{&ReadCondition{IncludeHeader: true, OnHeaderMismatch: MismatchRemap},
+			"id,date,name,status\n3,8/25/2022,Dagon,FAILED\n2,8/25/2022,Limeburn,OK\n1,8/24/2022,Rainger,\n"},
+		{&ReadCondition{IncludeHeader: true, OnHeaderMismatch: MismatchRemap, Where: MustParseExpr(`status != "FAILED"`), SelectColumns: ColumnNames{"name", "status"}},
+			"name,status\nLimeburn,OK\nRainger,\n"},
+		// every source with its own header, given before its rows
+		{&ReadCondition{IncludeHeader: true, OnHeaderMismatch: MismatchMark, Where: MustParseExpr(`name == "Dagon" || name == "Rainger"`)},
+			"id,date,status,name\n3,8/25/2022,FAILED,Dagon\nid,date,name\n1,8/24/2022,Rainger\n"},
+		// the other formats follow the header too
+		{&ReadCondition{Format: FormatNDJSON, OnHeaderMismatch: MismatchMark, Where: MustParseExpr(`name == "Dagon" || name == "Rainger"`)},
+			`{"id":"3","date":"8/25/2022","status":"FAILED","name":"Dagon"}` + "\n" + `{"id":"1","date":"8/24/2022","name":"Rainger"}` + "\n"},
+		{&ReadCondition{Format: FormatCSV, IncludeHeader: true, OnHeaderMismatch: MismatchMark, Where: MustParseExpr(`id != 2`)},
+			"id,date,status,name\n3,8/25/2022,FAILED,Dagon\nid,date,name\n1,8/24/2022,Rainger\n"},
+		{&ReadCondition{Format: FormatTable, OnHeaderMismatch: MismatchMark, Where: MustParseExpr(`id != 2`)},
+			"id  date       status  name\n--  ---------  ------  -----\n3   8/25/2022  FAILED  Dagon\n\nid  date       name\n--  ---------  -------\n1   8/24/2022  Rainger\n"},
+		{&ReadCondition{OnHeaderMismatch: MismatchMark, RowLimit: 1},
+			"id,date,status,name\n3,8/25/2022,FAILED,Dagon\n"},
+		// no marker where the header doesn't change, from the end of the older source
+		{&ReadCondition{OnHeaderMismatch: MismatchMark, StartAt: &Cursor{Source: 0, Offset: int64(len(older))}},
+			"1,8/24/2022,Rainger\n"},
+	}
+	for i, c := range cases {
+		var experiment_text bytes.Buffer
+		it := newRecordIterator(c.cond, 16, newBlobSource(newFakeBlob(older)), newBlobSource(newFakeBlob(newer)))
+		if _, err := writeRows(context.Background(), &experiment_text, it); err != nil {
+			t.Fatal(err)
+		}
+		if experiment_text.String() != c.control_text {
+			t.Errorf("case %d: got %q", i, experiment_text.String())
+		}
+	}
+
+	// the row after a marker can still be resumed from
+	it := newRecordIterator(&ReadCondition{OnHeaderMismatch: MismatchMark}, 16, newBlobSource(newFakeBlob(older)), newBlobSource(newFakeBlob(newer)))
+	if !it.Next() || !it.Record().SchemaChange || it.Cursor() != (Cursor{Source: 1, Offset: int64(len(newer))}) {
+		t.Fatalf("got %+v at %v", it.Record(), it.Cursor())
+	}
+	if !it.Next() || it.Record().SchemaChange || string(it.Record().Data) != "3,8/25/2022,FAILED,Dagon\n" || it.Cursor().Offset != it.Record().Offset {
+		t.Fatalf("got %+v at %v", it.Record(), it.Cursor())
+	}
+}
+
+func TestHeaderlessHooks(t *testing.T) {
+	fd, err := os.CreateTemp(t.TempDir(), "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	fd.WriteString("ERROR first\nok\nERROR last\n")
+
+	cases := []*ReadCondition{
+		{Keep: func(row Row) bool { return bytes.Contains(row.Bytes(), []byte("ERROR")) }},
+		{Where: MustParseExpr(`$1 == "ERROR"`), Separator: ' '},
+	}
+	for i, cond := range cases {
+		var experiment_text bytes.Buffer
+		if _, err := writeRows(context.Background(), &experiment_text, NewFileIterator(cond, fd)); err != nil {
+			t.Fatal(err)
+		}
+		if experiment_text.String() != "ERROR last\nERROR first\n" {
+			t.Errorf("case %d: got %q", i, experiment_text.String())
+		}
+	}
+}
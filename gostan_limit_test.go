@@ -0,0 +1,125 @@
+package gostan
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaxBytesScanned(t *testing.T) {
+	fd1, err := os.Open("./mockfile1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd1.Close()
+	fd2, err := os.Open("./mockfile2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd2.Close()
+	mockfile1, _ := os.ReadFile("./mockfile1")
+	mockfile2, _ := os.ReadFile("./mockfile2")
+	content := string(mockfile1) + "\n" + string(mockfile2) + "\n" // the rows end with the delimiter
+
+	for name, newIterator := range map[string]func(cond *ReadCondition) *RecordIterator{
+		"file": func(cond *ReadCondition) *RecordIterator { return NewFileIterator(cond, fd1, fd2) },
+		"mmap": func(cond *ReadCondition) *RecordIterator {
+			cond.Mmap = true
+			return NewFileIterator(cond, fd1, fd2)
+		},
+		"blob": func(cond *ReadCondition) *RecordIterator {
+			return newRecordIterator(cond, cond.BufferSize, newBlobSource(newFakeBlob(mockfile1)), newBlobSource(newFakeBlob(mockfile2)))
+		},
+	} {
+		it := newIterator(&ReadCondition{MaxBytesScanned: 100, BufferSize: 16})
+		experiment_text := ""
+		for it.Next() {
+			experiment_text = string(it.Record().Data) + experiment_text
+		}
+		if !errors.Is(it.Err(), ErrLimitReached) || it.Limit() != LimitBytesScanned {
+			t.Fatalf("%s: got %v, %v", name, it.Err(), it.Limit())
+		}
+		// whole rows out of the last 100 bytes
+		if !strings.HasSuffix(content, experiment_text) || len(experiment_text) > 100 || len(experiment_text) < 75 {
+			t.Errorf("%s: got %q", name, experiment_text)
+		}
+
+		// the rest is read from the cursor
+		rest := newIterator(&ReadCondition{StartAt: &Cursor{Source: 1, Offset: it.Cursor().Offset}})
+		for rest.Next() {
+			experiment_text = string(rest.Record().Data) + experiment_text
+		}
+		if rest.Err() != nil || rest.Limit() != NoLimit {
+			t.Fatalf("%s: %v", name, rest.Err())
+		}
+		if experiment_text != content {
+			t.Errorf("%s: got %q once resumed", name, experiment_text)
+		}
+	}
+}
+
+func TestMaxBytesEmitted(t *testing.T) {
+	fd, err := os.Open("./mockfile2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	it := NewFileIterator(&ReadCondition{MaxBytesEmitted: 50}, fd)
+	rows := []string{}
+	for it.Next() {
+		rows = append(rows, string(it.Record().Data))
+	}
+	if !errors.Is(it.Err(), ErrLimitReached) || it.Limit() != LimitBytesEmitted {
+		t.Fatalf("got %v", it.Err())
+	}
+	if strings.Join(rows, "") != "10,8/25/2022,Truelove\n9,8/25/2022,Druery\n" {
+		t.Errorf("got %q", rows)
+	}
+
+	// the row that didn't fit is the next one
+	it = NewFileIterator(&ReadCondition{StartAt: &Cursor{Source: 0, Offset: it.Cursor().Offset}, RowLimit: 1}, fd)
+	if !it.Next() || string(it.Record().Data) != "8,8/25/2022,Laux\n" {
+		t.Errorf("got %q", it.Record().Data)
+	}
+
+	// the header marker counts as well
+	older := []byte("id,date,name\n1,8/24/2022,Rainger\n")
+	newer := []byte("id,date,status,name\n2,8/25/2022,OK,Limeburn\n3,8/25/2022,FAILED,Dagon\n")
+	for limit, control := range map[int64]int{44: 0, 45: 2} {
+		it = newRecordIterator(&ReadCondition{OnHeaderMismatch: MismatchMark, MaxBytesEmitted: limit}, 16,
+			newBlobSource(newFakeBlob(older)), newBlobSource(newFakeBlob(newer)))
+		records := 0
+		for it.Next() {
+			records++
+		}
+		if records != control || it.Limit() != LimitBytesEmitted {
+			t.Errorf("limit %d: got %d records, %v", limit, records, it.Err())
+		}
+	}
+
+	// within the limits
+	it = NewFileIterator(&ReadCondition{MaxBytesEmitted: 1 << 20, MaxBytesScanned: 1 << 20, MaxDuration: time.Hour}, fd)
+	for it.Next() {
+	}
+	if it.Err() != nil || it.Limit() != NoLimit {
+		t.Errorf("got %v", it.Err())
+	}
+}
+
+func TestMaxDuration(t *testing.T) {
+	fd, err := os.Open("./mockfile2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, w := io.Pipe()
+	go ReverseReadFiles(w, &ReadCondition{MaxDuration: time.Nanosecond}, fd)
+	time.Sleep(time.Millisecond)
+	experiment_text, err := io.ReadAll(r)
+	if !errors.Is(err, ErrLimitReached) || !strings.Contains(err.Error(), "MaxDuration") || len(experiment_text) != 0 {
+		t.Errorf("got %q, %v", experiment_text, err)
+	}
+}
@@ -1,32 +1,23 @@
 package gostan
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"regexp"
 	"testing"
-
-	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
-	"github.com/joho/godotenv"
-)
-
-var (
-	accountName string
-	accountKey  string
-	blobURL     string
+	"time"
 )
 
-func init() {
-	err := godotenv.Load()
+// mockBlobClient serves ./mockblob from memory
+func mockBlobClient() *fakeBlob {
+	data, err := os.ReadFile("./mockblob")
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		panic(fmt.Sprintf("open file error:%s", err.Error()))
 	}
-
-	accountName = os.Getenv("ACCOUNT_NAME")
-	accountKey = os.Getenv("ACCOUNT_KEY")
-	blobURL = os.Getenv("BLOB_URL")
+	return newFakeBlob(data)
 }
 
 func TestReverseReadBlobIncludeHeader(t *testing.T) {
@@ -52,16 +43,7 @@ func TestReverseReadBlobIncludeHeader(t *testing.T) {
 2,8/25/2022,Withur
 1,8/25/2022,Schoenleiter
 `
-	cred, err := azblob.NewSharedKeyCredential(accountName, accountKey)
-	if err != nil {
-		fmt.Println(err.Error())
-	}
-
-	blobClient, err := azblob.NewBlockBlobClientWithSharedKey(blobURL, cred, nil)
-
-	if err != nil {
-		fmt.Println(err.Error())
-	}
+	blobClient := mockBlobClient()
 	r, w := io.Pipe()
 
 	go ReverseReadBlob(w, blobClient, MAX_LENGTH, &ReadCondition{IncludeHeader: true})
@@ -91,16 +73,7 @@ func TestReverseReadBlobRowLimit(t *testing.T) {
 17,8/24/2022,Jacmard
 16,8/24/2022,Cutler
 `
-	cred, err := azblob.NewSharedKeyCredential(accountName, accountKey)
-	if err != nil {
-		fmt.Println(err.Error())
-	}
-
-	blobClient, err := azblob.NewBlockBlobClientWithSharedKey(blobURL, cred, nil)
-
-	if err != nil {
-		fmt.Println(err.Error())
-	}
+	blobClient := mockBlobClient()
 	r, w := io.Pipe()
 
 	go ReverseReadBlob(w, blobClient, MAX_LENGTH, &ReadCondition{IncludeHeader: true, RowLimit: 5})
@@ -135,19 +108,10 @@ func TestReverseReadBlobStopColDiff(t *testing.T) {
 12,8/24/2022,Limeburn
 11,8/24/2022,Rainger
 `
-	cred, err := azblob.NewSharedKeyCredential(accountName, accountKey)
-	if err != nil {
-		fmt.Println(err.Error())
-	}
-
-	blobClient, err := azblob.NewBlockBlobClientWithSharedKey(blobURL, cred, nil)
-
-	if err != nil {
-		fmt.Println(err.Error())
-	}
+	blobClient := mockBlobClient()
 	r, w := io.Pipe()
 
-	go ReverseReadBlob(w, blobClient, MAX_LENGTH, &ReadCondition{IncludeHeader: true, StopIfColValuesDiffer: ColumnNames{"Date"}})
+	go ReverseReadBlob(w, blobClient, MAX_LENGTH, &ReadCondition{IncludeHeader: true, StopIfColValuesDiffer: ColumnNames{"date"}})
 
 	experiment_text := ""
 	for {
@@ -175,16 +139,7 @@ func TestReverseReadBlobStopRegexMatched(t *testing.T) {
 16,8/24/2022,Cutler
 15,8/24/2022,Chaucer
 `
-	cred, err := azblob.NewSharedKeyCredential(accountName, accountKey)
-	if err != nil {
-		fmt.Println(err.Error())
-	}
-
-	blobClient, err := azblob.NewBlockBlobClientWithSharedKey(blobURL, cred, nil)
-
-	if err != nil {
-		fmt.Println(err.Error())
-	}
+	blobClient := mockBlobClient()
 	r, w := io.Pipe()
 
 	go ReverseReadBlob(w, blobClient, MAX_LENGTH, &ReadCondition{IncludeHeader: true, StopIfRegexMatched: regexp.MustCompile("Bellord")})
@@ -219,16 +174,7 @@ func TestReverseReadBlobAllCondition(t *testing.T) {
 12,8/24/2022,Limeburn
 11,8/24/2022,Rainger
 `
-	cred, err := azblob.NewSharedKeyCredential(accountName, accountKey)
-	if err != nil {
-		fmt.Println(err.Error())
-	}
-
-	blobClient, err := azblob.NewBlockBlobClientWithSharedKey(blobURL, cred, nil)
-
-	if err != nil {
-		fmt.Println(err.Error())
-	}
+	blobClient := mockBlobClient()
 	r, w := io.Pipe()
 
 	cols := ColumnNames{"date"}
@@ -275,16 +221,7 @@ func TestReverseReadBlobNoCondition(t *testing.T) {
 1,8/25/2022,Schoenleiter
 id,date,name
 `
-	cred, err := azblob.NewSharedKeyCredential(accountName, accountKey)
-	if err != nil {
-		fmt.Println(err.Error())
-	}
-
-	blobClient, err := azblob.NewBlockBlobClientWithSharedKey(blobURL, cred, nil)
-
-	if err != nil {
-		fmt.Println(err.Error())
-	}
+	blobClient := mockBlobClient()
 	r, w := io.Pipe()
 
 	go ReverseReadBlob(w, blobClient, MAX_LENGTH, &ReadCondition{})
@@ -305,3 +242,138 @@ id,date,name
 		t.Fail()
 	}
 }
+
+func TestReverseReadBlobFailedRange(t *testing.T) {
+	control_text := `20,8/24/2022,Dagon
+19,8/24/2022,Welfare
+18,8/24/2022,Tunsley
+`
+	blobClient := mockBlobClient()
+	// the rows of the first download go out, then the read fails
+	blobClient.failDownload = func(offset int64, count int64) error {
+		if offset > 0 && offset+count < int64(len(blobClient.data)) {
+			return errFakeBlob
+		}
+		return nil
+	}
+	r, w := io.Pipe()
+
+	go ReverseReadBlob(w, blobClient, 64, &ReadCondition{})
+
+	experiment_text := ""
+	var read_err error
+	for {
+		buff := make([]byte, MAX_LENGTH)
+		n, err := r.Read(buff)
+		if n != 0 {
+			experiment_text += string(buff[:n])
+		}
+		if err != nil {
+			read_err = err
+			break
+		}
+	}
+	if experiment_text != control_text || !errors.Is(read_err, errFakeBlob) {
+		t.Errorf("got %q, %v", experiment_text, read_err)
+	}
+
+	// the cursor is where the failure happened, the read can be resumed from it
+	blobClient = mockBlobClient()
+	blobClient.failDownload = func(offset int64, count int64) error {
+		if offset > 0 && offset < 150 {
+			return errFakeBlob
+		}
+		return nil
+	}
+	rows := 0
+	cursor, err := ReverseReadBlobFunc(blobClient, 64, &ReadCondition{}, func(line []byte) (bool, error) {
+		rows++
+		return false, nil
+	})
+	if !errors.Is(err, errFakeBlob) || cursor.AtStart() {
+		t.Fatalf("got %v, %v", cursor, err)
+	}
+	blobClient.failDownload = nil
+	_, err = ReverseReadBlobFunc(blobClient, 64, &ReadCondition{StartAt: &cursor}, func(line []byte) (bool, error) {
+		rows++
+		return false, nil
+	})
+	if err != nil || rows != 21 {
+		t.Errorf("got %d rows, %v", rows, err)
+	}
+}
+
+func TestReverseReadBlobCanceled(t *testing.T) {
+	// nothing passes the filter, the read still stops as soon as the caller gives up
+	ctx, cancel := context.WithCancel(context.Background())
+	blobClient := mockBlobClient()
+	blobClient.failDownload = func(offset int64, count int64) error {
+		if len(blobClient.downloads) == 2 {
+			cancel()
+		}
+		return nil
+	}
+	it := NewBlobIterator(blobClient, 16, &ReadCondition{RegexFilter: regexp.MustCompile("nothing")})
+	_, err := writeRows(ctx, io.Discard, it)
+	if !errors.Is(err, context.Canceled) || len(blobClient.downloads) > 3 {
+		t.Errorf("got %v after %d downloads", err, len(blobClient.downloads))
+	}
+}
+
+func TestReverseReadBlobProperties(t *testing.T) {
+	defer func(attempts int, wait time.Duration) {
+		blob_size_attempts, blob_size_retry_wait = attempts, wait
+	}(blob_size_attempts, blob_size_retry_wait)
+	blob_size_attempts, blob_size_retry_wait = 3, time.Millisecond
+
+	blobClient := mockBlobClient()
+	blobClient.failProperties = func(call int) error {
+		return errFakeBlob
+	}
+	_, err := ReverseReadBlobFunc(blobClient, MAX_LENGTH, &ReadCondition{}, func(line []byte) (bool, error) {
+		return false, nil
+	})
+	if !errors.Is(err, errFakeBlob) {
+		t.Errorf("got %v", err)
+	}
+
+	// an empty blob is waited for
+	blobClient = newFakeBlob(nil)
+	rows := 0
+	_, err = ReverseReadBlobFunc(blobClient, MAX_LENGTH, &ReadCondition{}, func(line []byte) (bool, error) {
+		rows++
+		return false, nil
+	})
+	if err != nil || rows != 0 || blobClient.properties != 4 {
+		t.Errorf("got %d rows after %d attempts, %v", rows, blobClient.properties, err)
+	}
+	// but not past MaxDuration
+	blob_size_attempts, blob_size_retry_wait = 60, time.Hour
+	_, err = ReverseReadBlobFunc(newFakeBlob(nil), MAX_LENGTH, &ReadCondition{MaxDuration: 10 * time.Millisecond}, func(line []byte) (bool, error) {
+		return false, nil
+	})
+	if !errors.Is(err, ErrLimitReached) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestReverseReadBlobAdaptiveBuffer(t *testing.T) {
+	for _, adaptive := range []bool{false, true} {
+		blobClient := mockBlobClient()
+		experiment_text := ""
+		_, err := ReverseReadBlobFunc(blobClient, 16, &ReadCondition{AdaptiveBuffer: adaptive}, func(line []byte) (bool, error) {
+			experiment_text = string(line) + experiment_text
+			return false, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if experiment_text != string(blobClient.data)+"\n" {
+			t.Errorf("adaptive %v: got %q", adaptive, experiment_text)
+		}
+		// the downloads get bigger, so there are fewer of them
+		if adaptive && len(blobClient.downloads) > 8 || !adaptive && len(blobClient.downloads) < 20 {
+			t.Errorf("adaptive %v: got %d downloads", adaptive, len(blobClient.downloads))
+		}
+	}
+}
@@ -323,3 +323,161 @@ id,date,name
 	}
 	// println(result)
 }
+
+func TestReverseReadFilesSkip(t *testing.T) {
+	control_text := `id,date,name
+10,8/24/2022,Dagon
+9,8/24/2022,Welfare
+8,8/24/2022,Tunsley
+7,8/24/2022,Jacmard
+6,8/24/2022,Cutler
+`
+	filename1 := "./mockfile1"
+	fd1, err := os.Open(filename1)
+	if err != nil {
+		panic(fmt.Sprintf("open file error:%s", err.Error()))
+	}
+	defer fd1.Close()
+
+	filename2 := "./mockfile2"
+	fd2, err := os.Open(filename2)
+	if err != nil {
+		panic(fmt.Sprintf("open file error:%s", err.Error()))
+	}
+	defer fd2.Close()
+
+	r, w := io.Pipe()
+
+	// second page of 5 rows after the first page of 10
+	go ReverseReadFiles(w, &ReadCondition{IncludeHeader: true, Skip: 10, RowLimit: 5}, fd1, fd2)
+
+	experiment_text := ""
+	for {
+		buff := make([]byte, 50)
+		n, err := r.Read(buff)
+		if n != 0 {
+			fmt.Print(string(buff[:n]))
+			experiment_text += string(buff[:n])
+		}
+		if err != nil {
+			break
+		}
+	}
+	if experiment_text != control_text {
+		t.Fail()
+	}
+}
+
+func TestReverseReadFilesHooks(t *testing.T) {
+	control_text := `id,date,name
+10,8/25/2022,Truelove
+8,8/25/2022,Laux
+`
+	filename1 := "./mockfile1"
+	fd1, err := os.Open(filename1)
+	if err != nil {
+		panic(fmt.Sprintf("open file error:%s", err.Error()))
+	}
+	defer fd1.Close()
+
+	filename2 := "./mockfile2"
+	fd2, err := os.Open(filename2)
+	if err != nil {
+		panic(fmt.Sprintf("open file error:%s", err.Error()))
+	}
+	defer fd2.Close()
+
+	r, w := io.Pipe()
+
+	cond := &ReadCondition{
+		IncludeHeader: true,
+		StopIf: func(row Row) bool {
+			name, _ := row.Column("name")
+			return name == "Monketon"
+		},
+		Keep: func(row Row) bool {
+			id, _ := row.Column("id")
+			return id != "" && (id[len(id)-1]-'0')%2 == 0
+		},
+	}
+	go ReverseReadFiles(w, cond, fd1, fd2)
+
+	experiment_text := ""
+	for {
+		buff := make([]byte, 50)
+		n, err := r.Read(buff)
+		if n != 0 {
+			fmt.Print(string(buff[:n]))
+			experiment_text += string(buff[:n])
+		}
+		if err != nil {
+			break
+		}
+	}
+	if experiment_text != control_text {
+		t.Fail()
+	}
+}
+
+func TestNewRow(t *testing.T) {
+	keep := func(row Row) bool {
+		id, _ := row.Column("id")
+		return id == "8"
+	}
+	if !keep(NewRow([]byte("8,8/25/2022,Laux"), ColumnNames{"id", "date", "name"})) || keep(NewRow([]byte("8,8/25/2022,Laux"), nil)) {
+		t.Error("unexpected keep")
+	}
+	if v, ok := NewRow([]byte("8,8/25/2022,Laux"), nil).Column("$3"); !ok || v != "Laux" {
+		t.Errorf("got %q", v)
+	}
+	if v, ok := (Row{}).Column("id"); ok || v != "" || !(Row{}).Malformed() {
+		t.Errorf("zero row: got %q", v)
+	}
+}
+
+func TestReverseReadFilesExpr(t *testing.T) {
+	control_text := `id,date,name
+10,8/25/2022,Truelove
+9,8/25/2022,Druery
+8,8/25/2022,Laux
+7,8/25/2022,Arghent
+`
+	filename1 := "./mockfile1"
+	fd1, err := os.Open(filename1)
+	if err != nil {
+		panic(fmt.Sprintf("open file error:%s", err.Error()))
+	}
+	defer fd1.Close()
+
+	filename2 := "./mockfile2"
+	fd2, err := os.Open(filename2)
+	if err != nil {
+		panic(fmt.Sprintf("open file error:%s", err.Error()))
+	}
+	defer fd2.Close()
+
+	r, w := io.Pipe()
+
+	cond := &ReadCondition{
+		IncludeHeader: true,
+		StopWhen:      MustParseExpr(`date < "8/25/2022"`),
+		Where:         MustParseExpr(`id >= 5 && (name =~ "^[A-L]" or not id != 10)`),
+	}
+	go ReverseReadFiles(w, cond, fd1, fd2)
+
+	experiment_text := ""
+	for {
+		buff := make([]byte, 50)
+		n, err := r.Read(buff)
+		if n != 0 {
+			fmt.Print(string(buff[:n]))
+			experiment_text += string(buff[:n])
+		}
+		if err != nil {
+			break
+		}
+	}
+	if experiment_text != control_text {
+		t.Fail()
+	}
+}
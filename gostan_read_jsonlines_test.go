@@ -0,0 +1,141 @@
+package gostan
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"testing"
+)
+
+func TestReverseReadFilesJSONLinesStopColDiff(t *testing.T) {
+	control_text := `{"id":6,"status":"OK","request":{"user":{"id":"u3"},"path":"/a"}}
+{"id":5,"status":"FAILED","request":{"user":{"id":"u3"},"path":"/b"}}
+`
+	filename := "./mockjsonl"
+	fd, err := os.Open(filename)
+	if err != nil {
+		panic(fmt.Sprintf("open file error:%s", err.Error()))
+	}
+	defer fd.Close()
+
+	r, w := io.Pipe()
+
+	cols := ColumnNames{"request.user.id"}
+	go ReverseReadFiles(w, &ReadCondition{JSONLines: true, StopIfColValuesDiffer: cols, OnMalformed: MalformedSkip}, fd)
+
+	experiment_text := ""
+	for {
+		buff := make([]byte, 50)
+		n, err := r.Read(buff)
+		if n != 0 {
+			fmt.Print(string(buff[:n]))
+			experiment_text += string(buff[:n])
+		}
+		if err != nil {
+			break
+		}
+	}
+	if experiment_text != control_text {
+		t.Fail()
+	}
+}
+
+func TestReverseReadFilesJSONLinesSelectAndFilter(t *testing.T) {
+	control_text := `{"id":5,"request.user.id":"u3"}
+{"id":3,"request.user.id":"u2"}
+`
+	filename := "./mockjsonl"
+	fd, err := os.Open(filename)
+	if err != nil {
+		panic(fmt.Sprintf("open file error:%s", err.Error()))
+	}
+	defer fd.Close()
+
+	r, w := io.Pipe()
+
+	cond := &ReadCondition{
+		JSONLines:     true,
+		IncludeHeader: true,
+		OnMalformed:   MalformedSkip,
+		SelectColumns: ColumnNames{"id", "request.user.id"},
+		ColumnFilter:  map[string]*regexp.Regexp{"status": regexp.MustCompile("^FAILED$")},
+	}
+	go ReverseReadFiles(w, cond, fd)
+
+	experiment_text := ""
+	for {
+		buff := make([]byte, 50)
+		n, err := r.Read(buff)
+		if n != 0 {
+			fmt.Print(string(buff[:n]))
+			experiment_text += string(buff[:n])
+		}
+		if err != nil {
+			break
+		}
+	}
+	if experiment_text != control_text {
+		t.Fail()
+	}
+}
+
+func TestReverseReadFilesJSONLinesMalformed(t *testing.T) {
+	tests := []struct {
+		policy       MalformedLinePolicy
+		control_text string
+	}{
+		{MalformedEmitRaw, `{"id":6,"status":"OK","request":{"user":{"id":"u3"},"path":"/a"}}
+not a json line
+{"id":4,"status":"OK","request":{"user":{"id":"u2"},"path":"/c"}}
+`},
+		{MalformedSkip, `{"id":6,"status":"OK","request":{"user":{"id":"u3"},"path":"/a"}}
+{"id":4,"status":"OK","request":{"user":{"id":"u2"},"path":"/c"}}
+{"id":2,"status":"OK","request":{"user":{"id":"u1"},"path":"/b"}}
+`},
+		{MalformedStop, `{"id":6,"status":"OK","request":{"user":{"id":"u3"},"path":"/a"}}
+`},
+	}
+
+	for _, test := range tests {
+		fd, err := os.Open("./mockjsonl")
+		if err != nil {
+			panic(fmt.Sprintf("open file error:%s", err.Error()))
+		}
+
+		r, w := io.Pipe()
+
+		cond := &ReadCondition{
+			JSONLines:    true,
+			OnMalformed:  test.policy,
+			ColumnFilter: map[string]*regexp.Regexp{"status": regexp.MustCompile("^OK$")},
+			RowLimit:     3,
+		}
+		go ReverseReadFiles(w, cond, fd)
+
+		b, _ := io.ReadAll(r)
+		if string(b) != test.control_text {
+			t.Errorf("policy %d: got %q", test.policy, string(b))
+		}
+		fd.Close()
+	}
+}
+
+func TestRowJSONLines(t *testing.T) {
+	row := newRow([]byte(`{"id":12345678901234567890,"request":{"user":{"id":"u1"},"tags":["a","b"]}}`), nil, ',', true)
+	if v, ok := row.Column("id"); !ok || v != "12345678901234567890" {
+		t.Errorf("id: got %q %v", v, ok)
+	}
+	if v, ok := row.Column("request.user.id"); !ok || v != "u1" {
+		t.Errorf("request.user.id: got %q %v", v, ok)
+	}
+	if v, ok := row.Column("request.tags.1"); !ok || v != "b" {
+		t.Errorf("request.tags.1: got %q %v", v, ok)
+	}
+	if _, ok := row.Column("request.user.name"); ok {
+		t.Error("request.user.name should not exist")
+	}
+	if newRow([]byte(`{"id":1} trailing`), nil, ',', true).Malformed() != true {
+		t.Error("trailing data should make the line malformed")
+	}
+}
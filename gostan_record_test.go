@@ -0,0 +1,335 @@
+package gostan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestFileIteratorRecords(t *testing.T) {
+	fd1, err := os.Open("./mockfile1")
+	if err != nil {
+		panic(fmt.Sprintf("open file error:%s", err.Error()))
+	}
+	defer fd1.Close()
+	fd2, err := os.Open("./mockfile2")
+	if err != nil {
+		panic(fmt.Sprintf("open file error:%s", err.Error()))
+	}
+	defer fd2.Close()
+
+	contents := [][]byte{}
+	for _, name := range []string{"./mockfile1", "./mockfile2"} {
+		b, _ := os.ReadFile(name)
+		contents = append(contents, b)
+	}
+
+	it := NewFileIterator(&ReadCondition{CountForwardLines: true}, fd1, fd2)
+	records := []Record{}
+	for it.Next() {
+		rec := it.Record()
+		rec.Data = append([]byte{}, rec.Data...)
+		records = append(records, rec)
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if len(records) != 22 {
+		t.Fatalf("got %d records", len(records))
+	}
+
+	for i, rec := range records {
+		content := contents[rec.SourceIndex]
+		if !bytes.HasPrefix(content[rec.Offset:], bytes.TrimSuffix(rec.Data, []byte{'\n'})) {
+			t.Errorf("record %d: %q is not at offset %d of %s", i, rec.Data, rec.Offset, rec.Source)
+		}
+		if rec.LineNumber+rec.ForwardLineNumber != 12 {
+			t.Errorf("record %d: line %d from the end and %d from the start of %s", i, rec.LineNumber, rec.ForwardLineNumber, rec.Source)
+		}
+	}
+
+	// the header of the latest file comes right before the rows of the older one
+	header := records[10]
+	if string(header.Data) != "id,date,name\n" || header.Source != "./mockfile2" || header.SourceIndex != 1 ||
+		header.Offset != 0 || header.LineNumber != 11 || header.ForwardLineNumber != 1 {
+		t.Errorf("unexpected header record %+v", header)
+	}
+	first := records[11]
+	if string(first.Data) != "10,8/24/2022,Dagon\n" || first.Source != "./mockfile1" || first.LineNumber != 1 {
+		t.Errorf("unexpected record %+v", first)
+	}
+	if !it.Cursor().AtStart() {
+		t.Errorf("cursor %v should be at the start", it.Cursor())
+	}
+}
+
+func TestFileIteratorBufferSize(t *testing.T) {
+	content := "id,note\n1," + strings.Repeat("a", 5000) + "\n2,b\n3," + strings.Repeat("c", 300) + "\r\n4,d"
+	fd, err := os.CreateTemp(t.TempDir(), "long")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	fd.WriteString(content)
+
+	control_text := "4,d\n3," + strings.Repeat("c", 300) + "\r\n2,b\n1," + strings.Repeat("a", 5000) + "\nid,note\n"
+	for _, cond := range []*ReadCondition{
+		{},
+		{BufferSize: 1},
+		{BufferSize: 7},
+		{BufferSize: 16, AdaptiveBuffer: true},
+		{BufferSize: 1, AdaptiveBuffer: true, Delimiter: []byte("\r\n")},
+	} {
+		want := control_text
+		if cond.Delimiter != nil {
+			// everything up to the \r\n is one line
+			want = "4,d\r\n" + content[:strings.Index(content, "4,d")]
+		}
+		experiment_text := ""
+		_, err := ReverseReadFilesFunc(cond, func(line []byte) (bool, error) {
+			experiment_text += string(line)
+			return false, nil
+		}, fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if experiment_text != want {
+			t.Errorf("buffer %d adaptive %v: got %d bytes %q...", cond.BufferSize, cond.AdaptiveBuffer, len(experiment_text), experiment_text[:20])
+		}
+	}
+
+	// the window grows to fit the long line, and its memory is reused
+	s := newBackwardScanner(&fileSource{fd: fd}, int64(len(content)), 16, []byte{'\n'}, true, false, false)
+	for s.scan() {
+	}
+	if s.bufferSize < 4096 || s.bufferSize > MAX_ADAPTIVE_LENGTH {
+		t.Errorf("got buffer size %d", s.bufferSize)
+	}
+	if len(s.mem) > 4*(len(content)) {
+		t.Errorf("got %d bytes of buffer", len(s.mem))
+	}
+}
+
+func TestFileIteratorMmap(t *testing.T) {
+	fd1, err := os.Open("./mockfile1")
+	if err != nil {
+		panic(fmt.Sprintf("open file error:%s", err.Error()))
+	}
+	defer fd1.Close()
+	fd2, err := os.Open("./mockfile2")
+	if err != nil {
+		panic(fmt.Sprintf("open file error:%s", err.Error()))
+	}
+	defer fd2.Close()
+	empty, err := os.Create(t.TempDir() + "/empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer empty.Close()
+
+	read := func(cond *ReadCondition) ([]Record, *RecordIterator) {
+		it := NewFileIterator(cond, fd1, empty, fd2)
+		records := []Record{}
+		for it.Next() {
+			rec := it.Record()
+			rec.Data = append([]byte{}, rec.Data...)
+			records = append(records, rec)
+		}
+		if it.Err() != nil {
+			t.Fatal(it.Err())
+		}
+		return records, it
+	}
+
+	control, _ := read(&ReadCondition{IncludeHeader: true, RegexFilter: regexp.MustCompile("[AL]")})
+	experiment, it := read(&ReadCondition{IncludeHeader: true, RegexFilter: regexp.MustCompile("[AL]"), Mmap: true})
+	if fmt.Sprint(control) != fmt.Sprint(experiment) || len(experiment) != 3 {
+		t.Errorf("got %v, want %v", experiment, control)
+	}
+	if it.mapped != nil {
+		t.Error("the mapping is still held")
+	}
+
+	// resuming in the middle of a mapped file
+	start := Cursor{Source: 2, Offset: control[1].Offset}
+	experiment, _ = read(&ReadCondition{IncludeHeader: true, RegexFilter: regexp.MustCompile("[AL]"), Mmap: true, StartAt: &start})
+	if fmt.Sprint(control[2:]) != fmt.Sprint(experiment) {
+		t.Errorf("got %v, want %v", experiment, control[2:])
+	}
+
+	// given up on before the end
+	it = NewFileIterator(&ReadCondition{Mmap: true}, fd1)
+	if !it.Next() || !bytes.Equal(it.Record().Data, []byte("10,8/24/2022,Dagon\n")) {
+		t.Errorf("got %q", it.Record().Data)
+	}
+	it.Close()
+	if it.mapped != nil || it.Next() {
+		t.Error("the iterator isn't closed")
+	}
+}
+
+func TestFileIteratorNUL(t *testing.T) {
+	content := "id,name\n1,a\x00b\n\x00\x00\n2,\x00c\x00\n\x00\x00\x00\x00"
+	fd, err := os.CreateTemp(t.TempDir(), "nul")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	fd.WriteString(content)
+
+	cases := []struct {
+		cond         *ReadCondition
+		control_text string
+	}{
+		// NUL bytes are data
+		{&ReadCondition{IncludeHeader: true}, "\x00\x00\x00\x00\n2,\x00c\x00\n\x00\x00\n1,a\x00b\n"},
+		// only the padding goes
+		{&ReadCondition{IncludeHeader: true, TrimNUL: true}, "2,\x00c\n1,a\x00b\n"},
+		{&ReadCondition{IncludeHeader: true, TrimNUL: true, Mmap: true, BufferSize: 3}, "2,\x00c\n1,a\x00b\n"},
+	}
+	for _, c := range cases {
+		experiment_text := ""
+		_, err := ReverseReadFilesFunc(c.cond, func(line []byte) (bool, error) {
+			experiment_text += string(line)
+			return false, nil
+		}, fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if experiment_text != c.control_text {
+			t.Errorf("trim %v: got %q", c.cond.TrimNUL, experiment_text)
+		}
+	}
+
+	// the padding lines are counted
+	fd, err = os.CreateTemp(t.TempDir(), "nul")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	fd.WriteString("a\nb\n\x00\x00\x00\x00")
+	it := NewFileIterator(&ReadCondition{TrimNUL: true, CountForwardLines: true}, fd)
+	for _, control := range []Record{{Data: []byte("b\n"), LineNumber: 2, ForwardLineNumber: 2}, {Data: []byte("a\n"), LineNumber: 3, ForwardLineNumber: 1}} {
+		if !it.Next() {
+			t.Fatal(it.Err())
+		}
+		rec := it.Record()
+		if string(rec.Data) != string(control.Data) || rec.LineNumber != control.LineNumber || rec.ForwardLineNumber != control.ForwardLineNumber {
+			t.Errorf("got %+v", rec)
+		}
+	}
+}
+
+func TestLineEndings(t *testing.T) {
+	content := "id,name\n\n1,a\n  \r\n2,b\r\n\n3,c"
+	fd, err := os.CreateTemp(t.TempDir(), "blank")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	fd.WriteString(content)
+
+	cases := []struct {
+		cond         ReadCondition
+		control_rows []string
+		control_text string // pipe output
+	}{
+		// the delimiter is added to the last line, blank lines are rows
+		{ReadCondition{},
+			[]string{"3,c\n", "\n", "2,b\r\n", "  \r\n", "1,a\n", "\n", "id,name\n"},
+			"3,c\n\n2,b\r\n  \r\n1,a\n\nid,name\n"},
+		{ReadCondition{IncludeHeader: true, SkipBlankLines: true},
+			[]string{"3,c\n", "2,b\r\n", "1,a\n"},
+			"id,name\n3,c\n2,b\r\n1,a\n"},
+		// blank lines don't count toward Skip and RowLimit
+		{ReadCondition{IncludeHeader: true, SkipBlankLines: true, Skip: 1, RowLimit: 1},
+			[]string{"2,b\r\n"},
+			"id,name\n2,b\r\n"},
+		// the pipe output keeps the rows apart
+		{ReadCondition{ExactLastLine: true},
+			[]string{"3,c", "\n", "2,b\r\n", "  \r\n", "1,a\n", "\n", "id,name\n"},
+			"3,c\n\n2,b\r\n  \r\n1,a\n\nid,name\n"},
+		{ReadCondition{ExactLastLine: true, SkipBlankLines: true, RowLimit: 2},
+			[]string{"3,c", "2,b\r\n"},
+			"3,c\n2,b\r\n"},
+	}
+	for i, c := range cases {
+		for _, bufferSize := range []int64{2, MAX_LENGTH} {
+			// the same from every kind of source
+			newIterators := map[string]func() *RecordIterator{
+				"file": func() *RecordIterator {
+					cond := c.cond
+					cond.BufferSize = bufferSize
+					return NewFileIterator(&cond, fd)
+				},
+				"mmap": func() *RecordIterator {
+					cond := c.cond
+					cond.BufferSize = bufferSize
+					cond.Mmap = true
+					return NewFileIterator(&cond, fd)
+				},
+				"blob": func() *RecordIterator {
+					cond := c.cond
+					return NewBlobIterator(newFakeBlob([]byte(content)), bufferSize, &cond)
+				},
+			}
+			for name, newIterator := range newIterators {
+				experiment_rows := []string{}
+				if err := newIterator().Each(func(line []byte) (bool, error) {
+					experiment_rows = append(experiment_rows, string(line))
+					return false, nil
+				}); err != nil {
+					t.Fatal(err)
+				}
+				if fmt.Sprintf("%q", experiment_rows) != fmt.Sprintf("%q", c.control_rows) {
+					t.Errorf("case %d %s buffer %d: got rows %q", i, name, bufferSize, experiment_rows)
+				}
+
+				var experiment_text bytes.Buffer
+				if _, err := writeRows(context.Background(), &experiment_text, newIterator()); err != nil {
+					t.Fatal(err)
+				}
+				if experiment_text.String() != c.control_text {
+					t.Errorf("case %d %s buffer %d: got output %q", i, name, bufferSize, experiment_text.String())
+				}
+			}
+		}
+	}
+}
+
+// shrunkSource says it's bigger than what can be read from it
+type shrunkSource struct {
+	memSource
+}
+
+func (s *shrunkSource) size() (int64, error) {
+	return int64(len(s.data)) + 10, nil
+}
+
+func TestShortRead(t *testing.T) {
+	src := &shrunkSource{memSource{data: []byte("a\nb\n")}}
+	s := newBackwardScanner(src, 14, 4, []byte{'\n'}, false, false, false)
+	if s.scan() || !errors.Is(s.readErr(), io.ErrUnexpectedEOF) {
+		t.Errorf("got %q, %v", s.bytes(), s.readErr())
+	}
+}
+
+func TestNoSource(t *testing.T) {
+	r, w := io.Pipe()
+	go ReverseReadFiles(w, &ReadCondition{})
+	experiment_text, err := io.ReadAll(r)
+	if err != nil || len(experiment_text) != 0 {
+		t.Errorf("got %q, %v", experiment_text, err)
+	}
+
+	it := newRecordIterator(&ReadCondition{Where: MustParseExpr(`id > 1`), OnHeaderMismatch: MismatchRemap, CountForwardLines: true}, 16)
+	if it.Next() || it.Err() != nil {
+		t.Errorf("got %+v, %v", it.Record(), it.Err())
+	}
+}
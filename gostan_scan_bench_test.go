@@ -0,0 +1,134 @@
+package gostan
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// memSource is a source over bytes in memory
+type memSource struct {
+	data []byte
+}
+
+func (m *memSource) size() (int64, error) {
+	return int64(len(m.data)), nil
+}
+
+func (m *memSource) readAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *memSource) name() string {
+	return "memory"
+}
+
+// perByteLastIndex is the delimiter search the scanner used to do, one byte at a time
+func perByteLastIndex(buf []byte, delim []byte, unscanned int, search_end int) int {
+	for i := unscanned - 1; i >= 0; i-- {
+		if i+len(delim) <= search_end && buf[i] == delim[0] && bytes.Equal(buf[i:i+len(delim)], delim) {
+			return i
+		}
+	}
+	return -1
+}
+
+// benchData makes about size bytes of CSV rows ending with the delimiter
+func benchData(size int, delim string) []byte {
+	r := rand.New(rand.NewSource(1))
+	var b bytes.Buffer
+	for i := 0; b.Len() < size; i++ {
+		fmt.Fprintf(&b, "%d,8/%d/2022,%s%s", i, r.Intn(28)+1, bytes.Repeat([]byte{'x'}, r.Intn(120)), delim)
+	}
+	return b.Bytes()
+}
+
+func TestLastIndex(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, delim := range [][]byte{[]byte("\n"), []byte("\r\n"), []byte("aba")} {
+		for n := 0; n < 2000; n++ {
+			buf := make([]byte, r.Intn(20))
+			for i := range buf {
+				buf[i] = "ab\r\nx"[r.Intn(5)]
+			}
+			search_end := r.Intn(len(buf) + 1)
+			unscanned := r.Intn(len(buf) + 1)
+			want := perByteLastIndex(buf, delim, unscanned, search_end)
+			if got := lastIndex(buf, delim, unscanned, search_end); got != want {
+				t.Fatalf("%q in %q unscanned %d search_end %d: got %d, want %d", delim, buf, unscanned, search_end, got, want)
+			}
+		}
+	}
+}
+
+// BenchmarkDelimiterSearch splits a buffer into lines from its end with the old and the new search
+func BenchmarkDelimiterSearch(b *testing.B) {
+	searches := map[string]func([]byte, []byte, int, int) int{
+		"per-byte":  perByteLastIndex,
+		"lastIndex": lastIndex,
+	}
+	for _, delim := range []string{"\n", "\r\n"} {
+		data := benchData(16<<20, delim)
+		for _, name := range []string{"per-byte", "lastIndex"} {
+			search := searches[name]
+			b.Run(fmt.Sprintf("%s/%q", name, delim), func(b *testing.B) {
+				b.SetBytes(int64(len(data)))
+				for n := 0; n < b.N; n++ {
+					end := len(data) - len(delim)
+					for end > 0 {
+						i := search(data, []byte(delim), end, end)
+						if i < 0 {
+							break
+						}
+						end = i
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkBackwardScanner reads a whole source with the scanner, from memory, from a file and from a mapped file
+func BenchmarkBackwardScanner(b *testing.B) {
+	data := benchData(16<<20, "\n")
+	fd, err := os.CreateTemp(b.TempDir(), "bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer fd.Close()
+	fd.Write(data)
+	mapped, err := newMmapSource(fd)
+	if err != nil {
+		b.Skip(err)
+	}
+	defer mapped.unmap()
+
+	sources := []struct {
+		name string
+		src  source
+	}{
+		{"memory", &memSource{data: data}},
+		{"file", &fileSource{fd: fd}},
+		{"mmap", mapped},
+	}
+	for _, s := range sources {
+		b.Run(s.name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for n := 0; n < b.N; n++ {
+				scanner := newBackwardScanner(s.src, int64(len(data)), 64<<10, []byte{'\n'}, false, false, false)
+				for scanner.scan() {
+				}
+			}
+		})
+	}
+}
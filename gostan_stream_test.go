@@ -0,0 +1,95 @@
+package gostan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReverseReadFilesFunc(t *testing.T) {
+	fd1, err := os.Open("./mockfile1")
+	if err != nil {
+		panic(fmt.Sprintf("open file error:%s", err.Error()))
+	}
+	fd2, err := os.Open("./mockfile2")
+	if err != nil {
+		panic(fmt.Sprintf("open file error:%s", err.Error()))
+	}
+	defer fd1.Close()
+	defer fd2.Close()
+
+	// stop once 3 distinct initials were seen, something ReadCondition cannot say
+	initials := map[byte]bool{}
+	experiment_text := ""
+	cursor, err := ReverseReadFilesFunc(&ReadCondition{IncludeHeader: true}, func(line []byte) (bool, error) {
+		initials[line[strings.LastIndexByte(string(line), ',')+1]] = true
+		if len(initials) > 3 {
+			return true, nil
+		}
+		experiment_text += string(line)
+		return false, nil
+	}, fd1, fd2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if experiment_text != "10,8/25/2022,Truelove\n9,8/25/2022,Druery\n8,8/25/2022,Laux\n" {
+		t.Errorf("got %q", experiment_text)
+	}
+	if cursor.Source != 1 || cursor.AtStart() {
+		t.Errorf("unexpected cursor %v", cursor)
+	}
+
+	// an error from the callback ends the read and comes back
+	failure := errors.New("failure")
+	_, err = ReverseReadFilesFunc(&ReadCondition{}, func(line []byte) (bool, error) {
+		return false, failure
+	}, fd1)
+	if err != failure {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestRecordIteratorRecords(t *testing.T) {
+	fd1, err := os.Open("./mockfile1")
+	if err != nil {
+		panic(fmt.Sprintf("open file error:%s", err.Error()))
+	}
+	defer fd1.Close()
+
+	it := NewFileIterator(&ReadCondition{IncludeHeader: true}, fd1)
+	experiment_text := ""
+	for rec := range it.Records(context.Background(), 2) {
+		experiment_text += string(rec.Data)
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if !strings.HasPrefix(experiment_text, "10,8/24/2022,Dagon\n9,8/24/2022,Welfare\n") || strings.Count(experiment_text, "\n") != 10 {
+		t.Errorf("got %q", experiment_text)
+	}
+
+	// the read stops once the consumer gives up
+	ctx, cancel := context.WithCancel(context.Background())
+	it = NewFileIterator(&ReadCondition{}, fd1)
+	records := it.Records(ctx, 0)
+	<-records
+	cancel()
+	for range records {
+	}
+	if it.Err() != context.Canceled {
+		t.Errorf("got %v", it.Err())
+	}
+
+	// unbuffered
+	it = NewFileIterator(&ReadCondition{}, fd1)
+	rows := 0
+	for range it.Records(context.Background(), -1) {
+		rows++
+	}
+	if rows != 11 || it.Err() != nil {
+		t.Errorf("got %d rows, %v", rows, it.Err())
+	}
+}
@@ -0,0 +1,74 @@
+package gostan
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSummarize(t *testing.T) {
+	fd1, err := os.Open("./mockfile1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd1.Close()
+	fd2, err := os.Open("./mockfile2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd2.Close()
+
+	summary, err := SummarizeFiles(&ReadCondition{RowLimit: 12}, SummaryOptions{TopK: 1}, fd1, fd2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	control_text := "12 rows, 0 malformed " +
+		"[{id number 12 10 1 10 [{10 2}]} " +
+		"{date date 12 2 8/24/2022 8/25/2022 [{8/25/2022 10}]} " +
+		"{name text 12 12   [{Arghent 1}]}]"
+	experiment_text := fmt.Sprintf("%d rows, %d malformed %v", summary.Rows, summary.Malformed, summary.Columns)
+	if experiment_text != control_text {
+		t.Errorf("got %s", experiment_text)
+	}
+}
+
+func TestSummarizeWindow(t *testing.T) {
+	cases := []struct {
+		content      string
+		cond         *ReadCondition
+		opts         SummaryOptions
+		control_text string
+	}{
+		// the rows of the last hour
+		{"time,status\n2022-08-24 10:00:00,OK\n2022-08-24 11:30:00,FAILED\n2022-08-24 12:00:00,OK\n2022-08-24 12:45:00,FAILED\n",
+			&ReadCondition{}, SummaryOptions{TimeColumn: "time", Window: time.Hour},
+			"2 rows [{time date 2 2 2022-08-24 12:00:00 2022-08-24 12:45:00 [{2022-08-24 12:00:00 1} {2022-08-24 12:45:00 1}]} " +
+				"{status text 2 2   [{FAILED 1} {OK 1}]}]"},
+		// columns by position without header
+		{"a,1\nb,2\nb,x\n", &ReadCondition{Header: HeaderNone}, SummaryOptions{},
+			"3 rows [{$1 text 3 2   [{b 2} {a 1}]} {$2 text 3 3   [{1 1} {2 1} {x 1}]}]"},
+		// fields of the objects, the malformed line is only counted
+		{"{\"id\":1,\"s\":\"a\"}\nnot json\n{\"id\":20}\n", &ReadCondition{JSONLines: true, OnMalformed: MalformedSkip}, SummaryOptions{TopK: 1},
+			"2 rows [{id number 2 2 1 20 [{1 1}]} {s text 1 1   [{a 1}]}]"},
+		{"{\"id\":1,\"s\":\"a\"}\nnot json\n", &ReadCondition{JSONLines: true}, SummaryOptions{},
+			"2 rows [{id number 1 1 1 1 [{1 1}]} {s text 1 1   [{a 1}]}]"},
+	}
+	for i, c := range cases {
+		fd, err := os.CreateTemp(t.TempDir(), "summary")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fd.Close()
+		fd.WriteString(c.content)
+
+		summary, err := SummarizeFiles(c.cond, c.opts, fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		experiment_text := fmt.Sprintf("%d rows %v", summary.Rows, summary.Columns)
+		if experiment_text != c.control_text {
+			t.Errorf("case %d: got %s", i, experiment_text)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package gostan
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// TailHandler streams reverse reads over HTTP. The sources and the ReadCondition come from the query string:
+//
+//	source          file path or az://account/container/blob, can be repeated, oldest first
+//	n, skip         RowLimit and Skip
+//	stop_regex      StopIfRegexMatched
+//	stop_col_diff   StopIfColValuesDiffer, comma separated
+//	filter          RegexFilter
+//	where           Where, a condition expression
+//	stop_when       StopWhen, a condition expression
+//	select          SelectColumns, comma separated
+//	dedup_by        DedupBy, comma separated
+//	dedup_cache     DedupCacheSize
+//	include_header  IncludeHeader
+//	header          default, first, none or detect
+//	columns         Columns, comma separated
+//	header_mismatch ignore, fail, remap or mark
+//	json            JSONLines
+//	adaptive_buffer AdaptiveBuffer
+//	trim_nul        TrimNUL
+//	skip_blank      SkipBlankLines
+//	encoding        auto, utf-8, utf-16le or utf-16be
+//	format          raw, csv, ndjson, markdown or table
+//	cursor          StartAt, as returned in the Gostan-Cursor trailer of the previous response
+//	max_bytes_scanned, max_bytes_emitted, max_duration
+//	                MaxBytesScanned, MaxBytesEmitted (rows as they are in the source) and MaxDuration e.g. 30s.
+//	                The limit that stopped the read, if any, is given in the Gostan-Limit trailer
+//
+// Errors found before the first row, e.g. a source with another header with header_mismatch=fail, are given as the
+// response status. The ones found once the rows are on their way are given in the Gostan-Error trailer.
+// The rows are written as they are read, with chunked transfer, and the read stops when the client goes away.
+// Only files within Dirs and blobs of Containers can be read
+type TailHandler struct {
+	Dirs       []string                           // local directories files can be read from
+	Containers map[string]*azblob.ContainerClient // blob containers that can be read, keyed by "account/container"
+	BufferSize int64                              // blob download size, MAX_LENGTH if not set
+}
+
+// CursorTrailer is the HTTP trailer the TailHandler gives the Cursor of the read in
+const CursorTrailer = "Gostan-Cursor"
+
+// LimitTrailer is the HTTP trailer the TailHandler gives the Limit that stopped the read in, when the rows are partial
+const LimitTrailer = "Gostan-Limit"
+
+// ErrorTrailer is the HTTP trailer the TailHandler gives the error that failed the read in, once the rows are sent
+const ErrorTrailer = "Gostan-Error"
+
+var errSourceNotAllowed = errors.New("source not allowed")
+
+func (h *TailHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	readCondition, err := readConditionFromQuery(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	names := query["source"]
+	if len(names) == 0 {
+		http.Error(w, "missing source", http.StatusBadRequest)
+		return
+	}
+	sources := make([]source, len(names))
+	for i, name := range names {
+		src, err := h.openSource(name)
+		if err != nil {
+			switch {
+			case errors.Is(err, errSourceNotAllowed):
+				http.Error(w, err.Error(), http.StatusForbidden)
+			case errors.Is(err, os.ErrNotExist):
+				http.Error(w, err.Error(), http.StatusNotFound)
+			default:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		if f, ok := src.(*fileSource); ok {
+			defer f.fd.Close()
+		}
+		sources[i] = src
+	}
+
+	bufferSize := h.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = MAX_LENGTH
+	}
+	it := newRecordIteratorContext(r.Context(), readCondition, bufferSize, sources...)
+	defer it.Close()
+	if err := it.open(); err != nil && !errors.Is(err, ErrLimitReached) {
+		if errors.Is(err, ErrHeaderMismatch) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType(readCondition))
+	w.Header().Set("Trailer", CursorTrailer+", "+LimitTrailer+", "+ErrorTrailer)
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	cursor, err := writeRows(r.Context(), &flushWriter{w: w, flusher: flusher}, it)
+	w.Header().Set(CursorTrailer, cursor.String())
+	switch {
+	case it.Limit() != NoLimit:
+		w.Header().Set(LimitTrailer, it.Limit().String())
+	case err != nil:
+		w.Header().Set(ErrorTrailer, err.Error())
+	}
+}
+
+// openSource opens a file within Dirs or a blob of Containers
+func (h *TailHandler) openSource(name string) (source, error) {
+	if strings.HasPrefix(name, "az://") {
+		account, path, _ := strings.Cut(strings.TrimPrefix(name, "az://"), "/")
+		container, blob, ok := strings.Cut(path, "/")
+		if !ok || blob == "" {
+			return nil, fmt.Errorf("invalid blob %q, expected az://account/container/blob", name)
+		}
+		containerClient, ok := h.Containers[account+"/"+container]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", errSourceNotAllowed, name)
+		}
+		blobClient, err := containerClient.NewBlockBlobClient(blob)
+		if err != nil {
+			return nil, err
+		}
+		return newBlobSource(blobClient), nil
+	}
+
+	var missing error
+	for _, dir := range h.Dirs {
+		path, err := resolveWithin(dir, name)
+		if errors.Is(err, os.ErrNotExist) {
+			// it may be in the next one
+			missing = err
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		fd, err := os.Open(path)
+		if err != nil {
+			// the path on the server isn't given out
+			return nil, &os.PathError{Op: "open", Path: name, Err: errors.Unwrap(err)}
+		}
+		return &fileSource{fd: fd}, nil
+	}
+	if missing != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return nil, fmt.Errorf("%w: %s", errSourceNotAllowed, name)
+}
+
+// resolveWithin returns the real path of name, relative to dir unless absolute, if it's within dir.
+// Symlinks are followed so they cannot lead out of dir. A name within dir that doesn't exist is an os.ErrNotExist
+func resolveWithin(dir string, name string) (string, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return "", err
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && isWithin(root, filepath.Clean(path)) {
+			return "", err
+		}
+		return "", errSourceNotAllowed
+	}
+	if !isWithin(root, resolved) {
+		return "", errSourceNotAllowed
+	}
+	return resolved, nil
+}
+
+// isWithin tells whether path is dir or below it
+func isWithin(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// readConditionFromQuery builds the ReadCondition out of the query string parameters
+func readConditionFromQuery(query url.Values) (*ReadCondition, error) {
+	readCondition := &ReadCondition{}
+	var err error
+	if v := query.Get("n"); v != "" {
+		if readCondition.RowLimit, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid n: %w", err)
+		}
+	}
+	if v := query.Get("skip"); v != "" {
+		if readCondition.Skip, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid skip: %w", err)
+		}
+	}
+	if v := query.Get("stop_regex"); v != "" {
+		if readCondition.StopIfRegexMatched, err = regexp.Compile(v); err != nil {
+			return nil, fmt.Errorf("invalid stop_regex: %w", err)
+		}
+	}
+	if v := query.Get("filter"); v != "" {
+		if readCondition.RegexFilter, err = regexp.Compile(v); err != nil {
+			return nil, fmt.Errorf("invalid filter: %w", err)
+		}
+	}
+	if v := query.Get("where"); v != "" {
+		if readCondition.Where, err = ParseExpr(v); err != nil {
+			return nil, fmt.Errorf("invalid where: %w", err)
+		}
+	}
+	if v := query.Get("stop_when"); v != "" {
+		if readCondition.StopWhen, err = ParseExpr(v); err != nil {
+			return nil, fmt.Errorf("invalid stop_when: %w", err)
+		}
+	}
+	if v := query.Get("stop_col_diff"); v != "" {
+		readCondition.StopIfColValuesDiffer = strings.Split(v, ",")
+	}
+	if v := query.Get("select"); v != "" {
+		readCondition.SelectColumns = strings.Split(v, ",")
+	}
+	if v := query.Get("dedup_by"); v != "" {
+		readCondition.DedupBy = strings.Split(v, ",")
+	}
+	if v := query.Get("dedup_cache"); v != "" {
+		if readCondition.DedupCacheSize, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid dedup_cache: %w", err)
+		}
+	}
+	if v := query.Get("include_header"); v != "" {
+		if readCondition.IncludeHeader, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid include_header: %w", err)
+		}
+	}
+	if v := query.Get("header"); v != "" {
+		if readCondition.Header, err = ParseHeaderMode(v); err != nil {
+			return nil, err
+		}
+	}
+	if v := query.Get("columns"); v != "" {
+		readCondition.Columns = strings.Split(v, ",")
+	}
+	if v := query.Get("header_mismatch"); v != "" {
+		if readCondition.OnHeaderMismatch, err = ParseHeaderMismatchPolicy(v); err != nil {
+			return nil, err
+		}
+	}
+	if v := query.Get("json"); v != "" {
+		if readCondition.JSONLines, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid json: %w", err)
+		}
+	}
+	if v := query.Get("adaptive_buffer"); v != "" {
+		if readCondition.AdaptiveBuffer, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid adaptive_buffer: %w", err)
+		}
+	}
+	if v := query.Get("trim_nul"); v != "" {
+		if readCondition.TrimNUL, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid trim_nul: %w", err)
+		}
+	}
+	if v := query.Get("skip_blank"); v != "" {
+		if readCondition.SkipBlankLines, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid skip_blank: %w", err)
+		}
+	}
+	if v := query.Get("encoding"); v != "" {
+		if readCondition.Encoding, err = ParseEncoding(v); err != nil {
+			return nil, err
+		}
+	}
+	if v := query.Get("max_bytes_scanned"); v != "" {
+		if readCondition.MaxBytesScanned, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid max_bytes_scanned: %w", err)
+		}
+	}
+	if v := query.Get("max_bytes_emitted"); v != "" {
+		if readCondition.MaxBytesEmitted, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid max_bytes_emitted: %w", err)
+		}
+	}
+	if v := query.Get("max_duration"); v != "" {
+		if readCondition.MaxDuration, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid max_duration: %w", err)
+		}
+	}
+	if v := query.Get("format"); v != "" {
+		if readCondition.Format, err = ParseOutputFormat(v); err != nil {
+			return nil, err
+		}
+	}
+	if v := query.Get("cursor"); v != "" {
+		cursor, err := ParseCursor(v)
+		if err != nil {
+			return nil, err
+		}
+		readCondition.StartAt = &cursor
+	}
+	return readCondition, nil
+}
+
+// contentType returns the content type of the output format
+func contentType(readCondition *ReadCondition) string {
+	switch {
+	case readCondition.Format == FormatNDJSON, readCondition.JSONLines && readCondition.Format == FormatRaw:
+		return "application/x-ndjson"
+	case readCondition.Format == FormatCSV:
+		return "text/csv; charset=utf-8"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+// flushWriter sends every write to the client straight away
+type flushWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if fw.flusher != nil {
+		fw.flusher.Flush()
+	}
+	return n, err
+}
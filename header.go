@@ -0,0 +1,225 @@
+package gostan
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// HeaderMode tells whether the sources start with a header line
+type HeaderMode int
+
+const (
+	// HeaderDefault reads the first line as header when the conditions may need column names, and leaves it out
+	// of the rows when columns are looked up by name, it's written out or compared. Otherwise it's a row like any
+	// other, e.g. for StopIf and Keep alone. With Columns it's HeaderNone
+	HeaderDefault   HeaderMode = iota
+	HeaderFirstLine            // the first line is the header, never a row
+	HeaderNone                 // there's no header, every line is a row. Columns are named by Columns or by position
+	// HeaderDetect takes the first line as the header if it looks like one, see looksLikeHeader. When the first
+	// source has none, the columns are named by the header of the source being read, or the last one found
+	HeaderDetect
+)
+
+func (m HeaderMode) String() string {
+	switch m {
+	case HeaderDefault:
+		return "default"
+	case HeaderFirstLine:
+		return "first"
+	case HeaderNone:
+		return "none"
+	case HeaderDetect:
+		return "detect"
+	}
+	return fmt.Sprintf("HeaderMode(%d)", int(m))
+}
+
+// ParseHeaderMode returns the HeaderMode of its name, as returned by String
+func ParseHeaderMode(name string) (HeaderMode, error) {
+	for _, m := range []HeaderMode{HeaderDefault, HeaderFirstLine, HeaderNone, HeaderDetect} {
+		if m.String() == name {
+			return m, nil
+		}
+	}
+	return HeaderDefault, fmt.Errorf("unknown header mode %q, expected default, first, none or detect", name)
+}
+
+// HeaderMismatchPolicy tells the reader what to do when a source doesn't have the header of the first one
+type HeaderMismatchPolicy int
+
+const (
+	MismatchIgnore HeaderMismatchPolicy = iota // read it with the columns of the first source (default)
+	MismatchFail                               // fail the read with ErrHeaderMismatch before any row of the source
+	// MismatchRemap rewrites the rows of every source into the union of the headers, by column name.
+	// The columns a source doesn't have are left empty
+	MismatchRemap
+	// MismatchMark reads the rows of every source with its own header, and gives that header out as a row marked
+	// Record.SchemaChange before the first row of a source whose header differs from the one above it
+	MismatchMark
+)
+
+func (p HeaderMismatchPolicy) String() string {
+	switch p {
+	case MismatchIgnore:
+		return "ignore"
+	case MismatchFail:
+		return "fail"
+	case MismatchRemap:
+		return "remap"
+	case MismatchMark:
+		return "mark"
+	}
+	return fmt.Sprintf("HeaderMismatchPolicy(%d)", int(p))
+}
+
+// ParseHeaderMismatchPolicy returns the HeaderMismatchPolicy of its name, as returned by String
+func ParseHeaderMismatchPolicy(name string) (HeaderMismatchPolicy, error) {
+	for _, p := range []HeaderMismatchPolicy{MismatchIgnore, MismatchFail, MismatchRemap, MismatchMark} {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return MismatchIgnore, fmt.Errorf("unknown header mismatch policy %q, expected ignore, fail, remap or mark", name)
+}
+
+// ErrHeaderMismatch is returned, wrapped, when a source doesn't have the header of the first one with MismatchFail
+var ErrHeaderMismatch = errors.New("header mismatch")
+
+// headerMode returns the HeaderMode of the read, HeaderDefault is only returned when it keeps its meaning
+func (readCondition *ReadCondition) headerMode() HeaderMode {
+	switch {
+	case readCondition.JSONLines:
+		return HeaderNone
+	case readCondition.Header != HeaderDefault:
+		return readCondition.Header
+	case readCondition.Columns != nil:
+		return HeaderNone
+	}
+	return HeaderDefault
+}
+
+// perSourceHeader tells whether the header of every source has to be read, rather than only the first one's
+func (readCondition *ReadCondition) perSourceHeader() bool {
+	mode := readCondition.headerMode()
+	return mode == HeaderDetect || (mode != HeaderNone && readCondition.OnHeaderMismatch != MismatchIgnore)
+}
+
+// unifiedHeader returns the columns of the first header followed by the ones the next headers add, in order
+func unifiedHeader(headers [][][]byte) [][]byte {
+	unified := [][]byte{}
+	seen := map[string]bool{}
+	for _, header := range headers {
+		for _, name := range header {
+			if !seen[string(name)] {
+				seen[string(name)] = true
+				unified = append(unified, name)
+			}
+		}
+	}
+	return unified
+}
+
+// remapIndex returns the index in header of every column of unified, -1 for the ones it doesn't have.
+// It returns nil if the header is the unified one
+func remapIndex(unified [][]byte, header [][]byte) []int {
+	index := make([]int, len(unified))
+	same := len(header) == len(unified)
+	for i, name := range unified {
+		index[i] = -1
+		for j, h := range header {
+			if bytes.Equal(h, name) {
+				index[i] = j
+				break
+			}
+		}
+		same = same && index[i] == i
+	}
+	if same {
+		return nil
+	}
+	return index
+}
+
+// readHeader reads the first line of the source, and tells whether it's its header. The line is nil if the source is empty
+func (readCondition *ReadCondition) readHeader(src source) ([]byte, bool, error) {
+	line, err := readFirstLine(src, readCondition.Encoding, readCondition.delimiter(), 1024)
+	if err != nil || line == nil {
+		return nil, false, err
+	}
+	if readCondition.headerMode() == HeaderDetect {
+		return line, looksLikeHeader(bytes.Split(line, []byte{readCondition.separator()})), nil
+	}
+	return line, readCondition.firstLineIsHeader(), nil
+}
+
+// columnNames returns Columns the way the header line is kept
+func (readCondition *ReadCondition) columnNames() [][]byte {
+	names := make([][]byte, len(readCondition.Columns))
+	for i, name := range readCondition.Columns {
+		names[i] = []byte(name)
+	}
+	return names
+}
+
+// looksLikeHeader tells whether the fields of the first line are column names rather than values:
+// none of them is empty, a number or a date, and no name is repeated
+func looksLikeHeader(fields [][]byte) bool {
+	if len(fields) == 0 {
+		return false
+	}
+	seen := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		name := string(bytes.TrimSpace(f))
+		if name == "" || seen[name] {
+			return false
+		}
+		seen[name] = true
+		if _, ok := parseNumber(name); ok {
+			return false
+		}
+		if _, ok := parseDate(name); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// byName tells whether any of the columns is named rather than given by position
+func (names ColumnNames) byName() bool {
+	for _, name := range names {
+		if !isPosition(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPosition tells whether the column name is a position, $1 for the first column
+func isPosition(name string) bool {
+	if len(name) < 2 || name[0] != '$' {
+		return false
+	}
+	n, err := strconv.Atoi(name[1:])
+	return err == nil && n > 0
+}
+
+// positionName is the name of the column at index i, $1 for the first one
+func positionName(i int) string {
+	return "$" + strconv.Itoa(i+1)
+}
+
+// columnIndex returns the index of the column by header name or position, or -1
+func columnIndex(headers [][]byte, name string) int {
+	for i, h := range headers {
+		if string(h) == name {
+			return i
+		}
+	}
+	if isPosition(name) {
+		n, _ := strconv.Atoi(name[1:])
+		return n - 1
+	}
+	return -1
+}
@@ -0,0 +1,98 @@
+package gostan
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MalformedLinePolicy tells the reader what to do with a line that cannot be parsed in JSON Lines mode
+type MalformedLinePolicy int
+
+const (
+	MalformedEmitRaw MalformedLinePolicy = iota // write the line out as it is (default)
+	MalformedSkip                               // drop the line and carry on
+	MalformedStop                               // stop reading
+)
+
+// parseJSONLine parses a JSON Lines row. The second value is true if the line isn't a JSON object
+func parseJSONLine(line []byte) (map[string]interface{}, bool) {
+	var obj map[string]interface{}
+	// keep numbers as they are written so big ids doesn't lose precision
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil || obj == nil || dec.More() {
+		return nil, true
+	}
+	return obj, false
+}
+
+// lookupField walks a dotted path such as "request.user.id" down the object.
+// Array elements are addressed by their index, e.g. "tags.0"
+func lookupField(obj map[string]interface{}, path string) (interface{}, bool) {
+	// a key that contains the dot itself wins over the nested path
+	if v, ok := obj[path]; ok {
+		return v, true
+	}
+	var cur interface{} = obj
+	for _, key := range strings.Split(path, ".") {
+		switch node := cur.(type) {
+		case map[string]interface{}:
+			v, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			cur = v
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			cur = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// columnString turns a column value into the string used for comparison and output
+func columnString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	case map[string]interface{}, []interface{}, bool:
+		b, err := json.Marshal(val)
+		if err != nil {
+			return fmt.Sprint(val)
+		}
+		return string(b)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// jsonProject builds a JSON object keyed by the selected field paths, in the order they were selected
+func jsonProject(mapped_row map[string]interface{}, names ColumnNames) []byte {
+	out := []byte{'{'}
+	for i, name := range names {
+		if i > 0 {
+			out = append(out, ',')
+		}
+		key, _ := json.Marshal(name)
+		val, err := json.Marshal(mapped_row[name])
+		if err != nil {
+			val = []byte("null")
+		}
+		out = append(out, key...)
+		out = append(out, ':')
+		out = append(out, val...)
+	}
+	return append(out, '}')
+}
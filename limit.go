@@ -0,0 +1,34 @@
+package gostan
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Limit is one of the budgets of ReadCondition a read can be stopped by
+type Limit int
+
+const (
+	NoLimit Limit = iota
+	LimitBytesScanned
+	LimitBytesEmitted
+	LimitDuration
+)
+
+func (l Limit) String() string {
+	switch l {
+	case NoLimit:
+		return "none"
+	case LimitBytesScanned:
+		return "MaxBytesScanned"
+	case LimitBytesEmitted:
+		return "MaxBytesEmitted"
+	case LimitDuration:
+		return "MaxDuration"
+	}
+	return fmt.Sprintf("Limit(%d)", int(l))
+}
+
+// ErrLimitReached is returned, wrapped, when the read is stopped by MaxBytesScanned, MaxBytesEmitted or MaxDuration.
+// The rows read until then are fine but there may be more, the Cursor tells where to carry on from
+var ErrLimitReached = errors.New("limit reached")
@@ -0,0 +1,53 @@
+package gostan
+
+import (
+	"errors"
+	"os"
+)
+
+var errMmapUnsupported = errors.New("mmap is not supported on this platform")
+
+// mmapSource is a local file mapped in memory. The scanner reads it in place, the lines it returns point into the mapping
+// so they are only good until unmap
+type mmapSource struct {
+	fileSource
+	data []byte
+}
+
+// newMmapSource maps the file, it fails for empty files and whatever can't be mapped such as pipes
+func newMmapSource(fd *os.File) (*mmapSource, error) {
+	info, err := fd.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() || info.Size() <= 0 || int64(int(info.Size())) != info.Size() {
+		return nil, errMmapUnsupported
+	}
+	data, err := mmapFile(fd, int(info.Size()))
+	if err != nil {
+		return nil, err
+	}
+	return &mmapSource{fileSource: fileSource{fd: fd}, data: data}, nil
+}
+
+func (m *mmapSource) size() (int64, error) {
+	return int64(len(m.data)), nil
+}
+
+func (m *mmapSource) readAt(p []byte, off int64) (int, error) {
+	return copy(p, m.data[off:]), nil
+}
+
+func (m *mmapSource) mapped() []byte {
+	return m.data
+}
+
+// unmap releases the mapping, the file stays open
+func (m *mmapSource) unmap() error {
+	if m.data == nil {
+		return nil
+	}
+	err := munmapFile(m.data)
+	m.data = nil
+	return err
+}
@@ -0,0 +1,553 @@
+package gostan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Record is a row read in reverse along with where it came from
+type Record struct {
+	Source      string // file name or blob URL
+	SourceIndex int    // index of the source in the list given to the reader
+	Offset      int64  // byte offset in the source where the row starts
+	LineNumber  int64  // line number counted from the end of the source (or from StartAt), the last line is 1
+	// ForwardLineNumber is the line number counted from the start of the source, the first line is 1.
+	// It's only known with ReadCondition.CountForwardLines, 0 otherwise
+	ForwardLineNumber int64
+	Data              []byte // the row as it is in the source with its trailing delimiter
+	// SchemaChange marks a header given out before the rows it applies to, with MismatchMark. Data is the header
+	SchemaChange bool
+}
+
+// RecordIterator pulls the reversed rows that pass the ReadCondition one at a time, without goroutine or pipe.
+// Record().Data is only valid until the next call to Next, copy it to keep it. With ReadCondition.Mmap it points
+// into the mapped file, which is unmapped once Next returns false or on Close.
+// SelectColumns and Format only apply to the pipe output, records always carry the row as it is in the source
+// except with MismatchRemap
+type RecordIterator struct {
+	readCondition *ReadCondition
+	checker       *rowChecker
+	sources       []source
+	bufferSize    int64
+	delim         []byte
+	first_header  []byte // header of the first source, nil if it has none
+
+	header_line bool // the first line of the source being read is the header, not a row
+
+	unified     [][]byte // columns the rows are remapped into, with MismatchRemap
+	remap_index []int    // index in the source being read of every unified column, nil if it has them all in order
+	remap_width int      // number of columns of the source being read
+	remapped    []byte   // reused for the remapped rows
+	last_header []byte   // header above the rows of the source being read, with MismatchMark
+	marker      []byte   // header to give out before the next row, with MismatchMark
+
+	// a row held back while the marker before it is given out
+	pending        Record
+	pending_cursor Cursor
+	has_pending    bool
+
+	index       int   // source being read
+	end         int64 // where the read of the source started
+	scanner     *backwardScanner
+	mapped      *mmapSource // mapping of the source being read, with Mmap
+	line_number int64
+	total_lines int64 // lines before end, with CountForwardLines
+
+	budget   *byteBudget // MaxBytesScanned
+	emitted  int64
+	deadline time.Time // MaxDuration
+	limit    Limit     // the limit the read was stopped by
+
+	ctx    context.Context // done once the read has to stop, at the deadline or when the caller gives up
+	parent context.Context // the caller's context ctx comes from
+	cancel context.CancelFunc
+
+	record Record
+	cursor Cursor
+	done   bool
+	err    error
+}
+
+// NewFileIterator returns a RecordIterator over local file(s). Like ReverseReadFiles, the files are given oldest first.
+// The files are not closed by the iterator
+func NewFileIterator(readCondition *ReadCondition, file_descriptors ...*os.File) *RecordIterator {
+	sources := make([]source, len(file_descriptors))
+	for i, fd := range file_descriptors {
+		sources[i] = &fileSource{fd: fd}
+	}
+	return newRecordIterator(readCondition, readCondition.BufferSize, sources...)
+}
+
+// NewBlobIterator returns a RecordIterator over an Azure blob
+func NewBlobIterator(blobClient BlobClient, bufferSize int64, readCondition *ReadCondition) *RecordIterator {
+	return newRecordIterator(readCondition, bufferSize, newBlobSource(blobClient))
+}
+
+func newRecordIterator(readCondition *ReadCondition, bufferSize int64, sources ...source) *RecordIterator {
+	return newRecordIteratorContext(context.Background(), readCondition, bufferSize, sources...)
+}
+
+// newRecordIteratorContext returns a RecordIterator whose read, the header included, stops once ctx is done
+func newRecordIteratorContext(ctx context.Context, readCondition *ReadCondition, bufferSize int64, sources ...source) *RecordIterator {
+	it := &RecordIterator{
+		readCondition: readCondition,
+		sources:       sources,
+		bufferSize:    bufferSize,
+		delim:         readCondition.delimiter(),
+		index:         len(sources) - 1,
+		end:           -1,
+	}
+	if readCondition.MaxBytesScanned > 0 {
+		it.budget = &byteBudget{limit: readCondition.MaxBytesScanned}
+	}
+	if readCondition.MaxDuration > 0 {
+		it.deadline = time.Now().Add(readCondition.MaxDuration)
+	}
+	it.setContext(ctx)
+
+	headers, err := it.readHeaders()
+	it.checker = newRowChecker(readCondition, headers)
+	it.unified = headers
+	if err != nil {
+		it.fail(err)
+		return it
+	}
+	if len(sources) == 0 {
+		// nothing to read
+		it.done = true
+	}
+	// start from the end of the last source, or from where the previous read stopped
+	if readCondition.StartAt != nil {
+		it.index = readCondition.StartAt.Source
+		it.end = readCondition.StartAt.Offset
+		switch {
+		case it.index < 0 || it.end < 0:
+			it.done = true
+			it.err = fmt.Errorf("invalid cursor %s", readCondition.StartAt)
+		case it.index >= len(sources):
+			it.done = true
+			it.err = fmt.Errorf("cursor %s is out of the %d sources", readCondition.StartAt, len(sources))
+		}
+	}
+	it.cursor = Cursor{Source: it.index, Offset: it.end}
+	return it
+}
+
+// readHeaders reads the header of the first source, or of all of them with MismatchRemap, if the read needs it.
+// It returns the columns the rows are read with
+func (it *RecordIterator) readHeaders() ([][]byte, error) {
+	readCondition := it.readCondition
+	var headers [][]byte
+	it.header_line = readCondition.firstLineIsHeader()
+	if readCondition.needHeader() && len(it.sources) > 0 {
+		line, err := readFirstLine(it.sources[0], readCondition.Encoding, readCondition.delimiter(), 1024)
+		if err != nil {
+			return nil, err
+		}
+		if line != nil {
+			headers = bytes.Split(line, []byte{readCondition.separator()})
+		}
+		if readCondition.headerMode() == HeaderDetect && !looksLikeHeader(headers) {
+			// it's a row, the columns go by position
+			it.header_line = false
+			headers = nil
+		}
+		if it.header_line {
+			it.first_header = line
+			it.last_header = line
+		}
+	}
+	if readCondition.OnHeaderMismatch == MismatchRemap && readCondition.headerMode() != HeaderNone {
+		// the rows are read with the columns of all the sources
+		all := [][][]byte{}
+		for _, src := range it.sources {
+			line, is_header, err := readCondition.readHeader(src)
+			if err != nil {
+				return nil, err
+			}
+			if is_header {
+				all = append(all, bytes.Split(line, []byte{readCondition.separator()}))
+			}
+		}
+		headers = unifiedHeader(all)
+	}
+	if readCondition.Columns != nil {
+		headers = readCondition.columnNames()
+	}
+	return headers, nil
+}
+
+// Next moves to the next (older) row that passes the ReadCondition. It returns false when there's no more row,
+// a stop condition is met or on error
+func (it *RecordIterator) Next() bool {
+	if it.has_pending {
+		it.has_pending = false
+		it.record = it.pending
+		it.cursor = it.pending_cursor
+		return true
+	}
+	for !it.done {
+		if it.scanner == nil && !it.openSource() {
+			return false
+		}
+
+		select {
+		case <-it.ctx.Done():
+			it.fail(it.ctx.Err())
+			return false
+		default:
+		}
+		if !it.scanner.scan() {
+			if err := it.scanner.readErr(); err != nil {
+				it.fail(err)
+				return false
+			}
+			if it.scanner.exhausted {
+				it.stopAt(LimitBytesScanned)
+				return false
+			}
+			// on to the previous source
+			it.release()
+			it.scanner = nil
+			it.index--
+			if it.index < 0 {
+				it.done = true
+			}
+			continue
+		}
+
+		row := it.scanner.bytes()
+		// the padding lines skipped by the scanner are still lines
+		it.line_number += it.scanner.skipped + 1
+		// to include header, we assume the header is the first line of every source so it's not a row.
+		// JSON Lines have no header so the first line is just another row
+		first_line := it.scanner.offset() == it.scanner.start
+		// what's left to read once past the line, only the BOM is left before the first one
+		cursor_offset := it.scanner.offset()
+		if first_line {
+			cursor_offset = 0
+		}
+		if first_line && it.header_line {
+			it.cursor.Offset = cursor_offset
+			continue
+		}
+		if it.readCondition.SkipBlankLines && len(bytes.TrimSpace(bytes.TrimSuffix(row, it.delim))) == 0 {
+			it.cursor.Offset = cursor_offset
+			continue
+		}
+		if it.remap_index != nil {
+			row = it.remap(row)
+		}
+
+		keep, stop := it.checker.check(row)
+		if stop {
+			it.finish()
+			return false
+		}
+		cursor := it.cursor
+		if keep && it.readCondition.MaxBytesEmitted > 0 {
+			size := int64(len(row))
+			if it.marker != nil {
+				size += int64(len(it.marker) + len(it.delim))
+			}
+			if it.emitted+size > it.readCondition.MaxBytesEmitted {
+				// the row is left to read
+				it.stopAt(LimitBytesEmitted)
+				return false
+			}
+			it.emitted += size
+		}
+		it.cursor.Offset = cursor_offset
+		if !keep {
+			continue
+		}
+
+		it.record = Record{
+			Source:      it.sources[it.index].name(),
+			SourceIndex: it.index,
+			Offset:      it.scanner.offset(),
+			LineNumber:  it.line_number,
+			Data:        row,
+		}
+		if it.readCondition.CountForwardLines {
+			it.record.ForwardLineNumber = it.total_lines - it.line_number + 1
+		}
+		if it.marker != nil {
+			// the row comes after the header it's read with, the cursor stays before the row until it's given out
+			it.pending, it.pending_cursor, it.has_pending = it.record, it.cursor, true
+			it.record = Record{
+				Source:       it.record.Source,
+				SourceIndex:  it.index,
+				Data:         append(append([]byte{}, it.marker...), it.delim...),
+				SchemaChange: true,
+			}
+			it.cursor = cursor
+			it.marker = nil
+		}
+		return true
+	}
+	it.finish()
+	return false
+}
+
+// open gets the source the read starts in ready, for the errors found there to come before the first row
+func (it *RecordIterator) open() error {
+	if !it.done && it.scanner == nil {
+		it.openSource()
+	}
+	return it.err
+}
+
+// openSource gets the scanner ready for the source at it.index
+func (it *RecordIterator) openSource() bool {
+	src := it.sources[it.index]
+	// only the source the read starts in may start from a cursor, the older ones are read from the end
+	if it.end < 0 || it.index != it.cursor.Source {
+		size, err := src.size()
+		if err != nil {
+			it.fail(err)
+			return false
+		}
+		it.end = size
+	}
+	it.cursor = Cursor{Source: it.index, Offset: it.end}
+	it.line_number = 0
+	encoding, bom, err := detectEncoding(src, it.readCondition.Encoding)
+	if err != nil {
+		it.fail(err)
+		return false
+	}
+
+	if it.readCondition.perSourceHeader() && !it.checkHeader(src) {
+		return false
+	}
+
+	if it.readCondition.CountForwardLines {
+		total, err := countLines(src, bom, it.end, it.bufferSize, encoding.encode(it.delim), encoding.unit())
+		if err != nil {
+			it.fail(err)
+			return false
+		}
+		it.total_lines = total
+	}
+
+	if f, ok := src.(*fileSource); ok && it.readCondition.Mmap {
+		// fall back to reading the file if it cannot be mapped
+		if m, err := newMmapSource(f.fd); err == nil && it.end <= int64(len(m.data)) {
+			it.mapped = m
+			src = m
+		} else if err == nil {
+			m.unmap()
+		}
+	}
+	it.scanner = newBackwardScanner(src, it.end, it.bufferSize, it.delim, it.readCondition.AdaptiveBuffer,
+		it.readCondition.TrimNUL, it.readCondition.ExactLastLine)
+	it.scanner.setEncoding(encoding, bom)
+	it.scanner.budget = it.budget
+	return true
+}
+
+// checkHeader reads the header of the source, to find whether it has one with HeaderDetect
+// and compare it to the first source's with OnHeaderMismatch
+func (it *RecordIterator) checkHeader(src source) bool {
+	line, is_header, err := it.readCondition.readHeader(src)
+	if err != nil {
+		it.fail(err)
+		return false
+	}
+	it.remap_index = nil
+	if line == nil {
+		// empty, no row to read with it anyway
+		return true
+	}
+	it.header_line = is_header
+	var header []byte
+	if it.header_line {
+		header = line
+	}
+	fields := bytes.Split(header, []byte{it.readCondition.separator()})
+	if header != nil && it.first_header == nil && it.readCondition.Columns == nil && it.readCondition.OnHeaderMismatch != MismatchRemap {
+		// the first source has no header to name the columns, the one found here does
+		it.checker.headers = fields
+	}
+
+	switch it.readCondition.OnHeaderMismatch {
+	case MismatchFail:
+		if !bytes.Equal(header, it.first_header) {
+			it.fail(fmt.Errorf("%w: %s starts with %q, %s with %q", ErrHeaderMismatch,
+				src.name(), header, it.sources[0].name(), it.first_header))
+			return false
+		}
+	case MismatchRemap:
+		if header != nil {
+			it.remap_index = remapIndex(it.unified, fields)
+			it.remap_width = len(fields)
+		}
+	case MismatchMark:
+		if header != nil {
+			it.checker.headers = fields
+			if !bytes.Equal(header, it.last_header) {
+				it.marker = header
+			}
+			it.last_header = header
+		}
+	}
+	return true
+}
+
+// remap rewrites the row into the unified columns
+func (it *RecordIterator) remap(row []byte) []byte {
+	sep := it.readCondition.separator()
+	values := splitColumns(string(bytes.TrimSuffix(row, it.delim)), sep)
+	if len(values) != it.remap_width {
+		// malformed, left as it is
+		return row
+	}
+	it.remapped = it.remapped[:0]
+	for i, j := range it.remap_index {
+		if i > 0 {
+			it.remapped = append(it.remapped, sep)
+		}
+		if j >= 0 {
+			it.remapped = append(it.remapped, values[j]...)
+		}
+	}
+	if bytes.HasSuffix(row, it.delim) {
+		it.remapped = append(it.remapped, it.delim...)
+	}
+	return it.remapped
+}
+
+// stopAt ends the read on a limit, it's reported as ErrLimitReached
+func (it *RecordIterator) stopAt(limit Limit) {
+	it.limit = limit
+	it.fail(fmt.Errorf("%w: %s", ErrLimitReached, limit))
+}
+
+func (it *RecordIterator) fail(err error) {
+	if it.limit == NoLimit && errors.Is(err, context.DeadlineExceeded) && !it.deadline.IsZero() && !time.Now().Before(it.deadline) {
+		// cut short by MaxDuration, maybe in the middle of a download
+		it.limit = LimitDuration
+		err = fmt.Errorf("%w: %s", ErrLimitReached, LimitDuration)
+	}
+	it.err = err
+	it.finish()
+}
+
+// finish ends the read
+func (it *RecordIterator) finish() {
+	it.done = true
+	it.release()
+	if it.cancel != nil {
+		it.cancel()
+	}
+}
+
+// setContext makes the read stop once ctx is done or at the MaxDuration deadline, blob downloads included
+func (it *RecordIterator) setContext(ctx context.Context) {
+	if ctx == it.parent {
+		return
+	}
+	if it.cancel != nil {
+		it.cancel()
+	}
+	it.parent = ctx
+	if it.deadline.IsZero() {
+		it.ctx, it.cancel = context.WithCancel(ctx)
+	} else {
+		it.ctx, it.cancel = context.WithDeadline(ctx, it.deadline)
+	}
+	for _, src := range it.sources {
+		if b, ok := src.(*blobSource); ok {
+			b.ctx = it.ctx
+		}
+	}
+}
+
+// release unmaps the source that was being read, if it was mapped
+func (it *RecordIterator) release() {
+	if it.mapped != nil {
+		it.mapped.unmap()
+		it.mapped = nil
+	}
+}
+
+// Close ends the read and releases what the iterator holds, for when it's given up on before Next returns false.
+// The files are not closed
+func (it *RecordIterator) Close() error {
+	it.finish()
+	return nil
+}
+
+// Record returns the row found by the last call to Next
+func (it *RecordIterator) Record() Record {
+	return it.record
+}
+
+// Err returns the error that stopped the iterator, if any
+func (it *RecordIterator) Err() error {
+	return it.err
+}
+
+// Limit returns the limit the read was stopped by, NoLimit if it wasn't
+func (it *RecordIterator) Limit() Limit {
+	return it.limit
+}
+
+// Cursor returns where the read got to, give it as ReadCondition.StartAt to carry on from there later
+func (it *RecordIterator) Cursor() Cursor {
+	return it.cursor
+}
+
+// countLines counts the lines in the source from start, after the BOM, to end, reading it forward.
+// Like the scanner, it only counts the delimiters that start on a code unit of the encoding
+func countLines(src source, start int64, end int64, bufferSize int64, delim []byte, unit int) (int64, error) {
+	if bufferSize <= 0 {
+		bufferSize = MAX_LENGTH
+	}
+	var lines int64
+	// the tail of the previous buffer is kept in front of the next one, for a delimiter cut in two
+	tail := len(delim) - 1
+	buf := make([]byte, int64(tail)+bufferSize)
+	kept := 0
+	counted_to := start // end of the last delimiter counted
+	for pos := start; pos < end; pos += bufferSize {
+		n := bufferSize
+		if pos+n > end {
+			n = end - pos
+		}
+		if err := readFull(src, buf[kept:kept+int(n)], pos); err != nil {
+			return 0, err
+		}
+		data := buf[:kept+int(n)]
+		data_start := pos - int64(kept)
+		for from := 0; from < len(data); {
+			i := bytes.Index(data[from:], delim)
+			if i < 0 {
+				break
+			}
+			at := data_start + int64(from+i)
+			if at >= counted_to && (at-start)%int64(unit) == 0 {
+				lines++
+				counted_to = at + int64(len(delim))
+				from += i + len(delim)
+			} else {
+				from += i + 1
+			}
+		}
+		from := len(data) - tail
+		if from < 0 {
+			from = 0
+		}
+		kept = copy(buf, data[from:])
+	}
+	if counted_to < end {
+		// the last line doesn't have to end with the delimiter
+		lines++
+	}
+	return lines, nil
+}
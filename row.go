@@ -0,0 +1,85 @@
+package gostan
+
+// Row is the row given to the ReadCondition hooks. Its columns are only parsed the first time one is asked for.
+// The zero Row has no column
+type Row struct {
+	line []byte
+	cols *rowColumns
+}
+
+type rowColumns struct {
+	headers   [][]byte
+	sep       byte
+	json      bool
+	parsed    bool
+	malformed bool
+	values    map[string]interface{} // columns by header name, or the JSON object
+}
+
+// NewRow returns the comma separated row, given without its delimiter, with the columns named by header.
+// Without header the columns go by position. It's meant for testing StopIf and Keep functions
+func NewRow(line []byte, header ColumnNames) Row {
+	var headers [][]byte
+	if header != nil {
+		headers = make([][]byte, len(header))
+		for i, name := range header {
+			headers[i] = []byte(name)
+		}
+	}
+	return newRow(line, headers, ',', false)
+}
+
+func newRow(line []byte, headers [][]byte, sep byte, json bool) Row {
+	return Row{
+		line: line,
+		cols: &rowColumns{headers: headers, sep: sep, json: json},
+	}
+}
+
+// Bytes returns the row as it is in the source, without its delimiter
+func (r Row) Bytes() []byte {
+	return r.line
+}
+
+// Column returns the value of the column by its header name or position ($1 is the first), or by its field path for JSON Lines.
+// ok is false if the row has no such column
+func (r Row) Column(name string) (string, bool) {
+	v, ok := r.Value(name)
+	return columnString(v), ok
+}
+
+// Value is like Column but returns the value as it is parsed, a string for CSV or any JSON value for JSON Lines
+func (r Row) Value(name string) (interface{}, bool) {
+	if !r.parse() {
+		return nil, false
+	}
+	if r.cols.json {
+		return lookupField(r.cols.values, name)
+	}
+	v, ok := r.cols.values[name]
+	return v, ok
+}
+
+// Malformed tells whether the row cannot be parsed into columns: not a JSON object for JSON Lines,
+// or not as many columns as the header for CSV
+func (r Row) Malformed() bool {
+	return !r.parse()
+}
+
+// parse parses the columns if not done yet, it returns false if the row is malformed
+func (r Row) parse() bool {
+	cols := r.cols
+	if cols == nil {
+		return false
+	}
+	if !cols.parsed {
+		cols.parsed = true
+		if cols.json {
+			cols.values, cols.malformed = parseJSONLine(r.line)
+		} else {
+			cols.values = stringToMap(string(r.line), cols.headers, cols.sep)
+			cols.malformed = cols.values == nil
+		}
+	}
+	return !cols.malformed
+}
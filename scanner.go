@@ -0,0 +1,288 @@
+package gostan
+
+import (
+	"bytes"
+)
+
+// backwardScanner walks a source from a given position back to its start, a line at a time.
+// Every line comes with its trailing delimiter, the delimiter is added to the last line of the source if it doesn't have one
+// unless exact is set.
+// The line is only valid until the next call to scan
+type backwardScanner struct {
+	src        source
+	delim      []byte // as it is in the source
+	line_delim []byte // the delimiter the lines end with once decoded
+	encoding   Encoding
+	start      int64 // where the text starts, after the BOM
+	bufferSize int64
+	adaptive   bool        // grow bufferSize when a line doesn't fit in it, and after every read of a blob
+	trim_nul   bool        // trim NUL padding off the lines and skip the lines that are only padding
+	exact      bool        // leave the last line of the source without delimiter if it doesn't have one
+	budget     *byteBudget // bytes left to read, shared by the scanners of a read. nil for no limit
+	exhausted  bool        // stopped by the budget
+	skipped    int64       // lines of padding skipped by the last scan, with trim_nul
+
+	mem        []byte // reused backing array, buf sits at mem[head:]
+	head       int
+	buf        []byte // bytes of the source from buf_start that are not returned as line yet
+	buf_start  int64  // position of buf[0] in the source
+	search_end int    // the delimiter of the previous line has to end before this index of buf
+	unscanned  int    // number of positions at the front of buf that haven't been looked at for the delimiter
+	line       []byte
+	decoded    []byte // reused for the lines transcoded to UTF-8
+	line_start int64
+	first_scan bool
+	err        error
+}
+
+// newBackwardScanner returns a scanner that reads the source backward from end
+func newBackwardScanner(src source, end int64, bufferSize int64, delim []byte, adaptive bool, trim_nul bool, exact bool) *backwardScanner {
+	if bufferSize <= 0 {
+		bufferSize = MAX_LENGTH
+	}
+	return &backwardScanner{
+		src:        src,
+		delim:      delim,
+		line_delim: delim,
+		encoding:   EncodingUTF8,
+		bufferSize: bufferSize,
+		adaptive:   adaptive,
+		trim_nul:   trim_nul,
+		exact:      exact,
+		buf_start:  end,
+		first_scan: true,
+	}
+}
+
+// setEncoding makes the scanner read text in enc that starts after a BOM of start bytes, the lines are given out in UTF-8
+func (s *backwardScanner) setEncoding(enc Encoding, start int64) {
+	s.encoding = enc
+	s.start = start
+	s.delim = enc.encode(s.line_delim)
+}
+
+// scan moves to the previous line. It returns false once the start of the source is reached or on error
+func (s *backwardScanner) scan() bool {
+	s.skipped = 0
+	for s.scanLine() {
+		// with exact, the last line may not end with the delimiter
+		delimited := bytes.HasSuffix(s.line, s.delim)
+		if s.encoding.unit() > 1 {
+			s.decoded = s.encoding.decode(s.decoded[:0], bytes.TrimSuffix(s.line, s.delim))
+			if delimited {
+				s.decoded = append(s.decoded, s.line_delim...)
+			}
+			s.line = s.decoded
+		}
+		if !s.trim_nul {
+			return true
+		}
+		body := s.line
+		if delimited {
+			body = body[:len(body)-len(s.line_delim)]
+		}
+		trimmed := bytes.Trim(body, "\x00")
+		if len(trimmed) == 0 && len(body) > 0 {
+			// nothing but padding
+			s.skipped++
+			continue
+		}
+		if len(trimmed) < len(body) {
+			s.line = trimmed[:len(trimmed):len(trimmed)]
+			if delimited {
+				s.line = append(s.line, s.line_delim...)
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// scanLine moves to the previous line, as it is in the source
+func (s *backwardScanner) scanLine() bool {
+	if s.err != nil {
+		return false
+	}
+	delim_len := len(s.delim)
+	reads := 0
+	for {
+		// look for the delimiter that ends the previous line, backward from where we stopped last time
+		delim_index := lastIndex(s.buf, s.delim, s.unscanned, s.search_end)
+		// in UTF-16 the delimiter has to start on a code unit, not in the middle of one
+		for delim_index >= 0 && (s.buf_start+int64(delim_index)-s.start)%int64(s.encoding.unit()) != 0 {
+			delim_index = lastIndex(s.buf, s.delim, delim_index, s.search_end)
+		}
+
+		if delim_index >= 0 {
+			line_index := delim_index + delim_len
+			s.setLine(s.buf[line_index:], s.buf_start+int64(line_index))
+			s.buf = s.buf[:line_index]
+			s.search_end = delim_index
+			s.unscanned = delim_index
+			return true
+		}
+		s.unscanned = 0
+
+		// start of the source, whatever is left is the first line
+		if s.buf_start <= s.start {
+			if len(s.buf) == 0 {
+				return false
+			}
+			s.setLine(s.buf, s.buf_start)
+			s.buf = nil
+			s.search_end = 0
+			return true
+		}
+
+		// the line started before our buffer, read another window in front of it
+		if s.adaptive && reads > 0 {
+			s.grow()
+		}
+		reads++
+		n := s.bufferSize
+		if n < int64(delim_len) {
+			n = int64(delim_len)
+		}
+		m, mapped := s.src.(mappedSource)
+		if mapped {
+			// nothing to read, take all of it in front of buf
+			n = s.buf_start - s.start
+		}
+		if n > s.buf_start-s.start {
+			n = s.buf_start - s.start
+		}
+		if s.budget != nil {
+			if n = s.budget.take(n); n == 0 {
+				s.exhausted = true
+				return false
+			}
+		}
+		var window []byte
+		if mapped {
+			window = m.mapped()[s.buf_start-n : s.buf_start]
+		} else {
+			window = s.window(int(n))
+			if err := readFull(s.src, window, s.buf_start-n); err != nil {
+				s.err = err
+				return false
+			}
+		}
+		if _, remote := s.src.(*blobSource); remote && s.adaptive {
+			// every download costs a round trip, fewer bigger ones are faster
+			s.grow()
+		}
+		if s.first_scan && len(s.buf) == 0 {
+			// the delimiter at the end of the source belongs to the last line, not the one before
+			s.search_end = int(n)
+			if bytes.HasSuffix(window, s.delim) {
+				s.search_end -= delim_len
+			}
+		} else {
+			s.search_end += int(n)
+		}
+		s.unscanned = int(n)
+		if mapped {
+			s.buf = m.mapped()[s.buf_start-n : s.buf_start+int64(len(s.buf))]
+		} else {
+			s.buf = s.mem[s.head : s.head+int(n)+len(s.buf)]
+		}
+		s.buf_start -= n
+	}
+}
+
+// window makes room for n bytes in front of buf and returns it, the memory is reused from one read to the next.
+// buf is moved, which is fine since the line it held is only valid until the next scan
+func (s *backwardScanner) window(n int) []byte {
+	if s.head < n {
+		size := len(s.mem)
+		// keep at least half of it free in front of buf, so buf isn't moved over and over as a long line grows
+		if size < n+len(s.buf) || len(s.buf) > size/2 {
+			size = 2 * (n + len(s.buf))
+			s.mem = make([]byte, size)
+		}
+		s.head = size - len(s.buf)
+		copy(s.mem[s.head:], s.buf)
+	}
+	s.head -= n
+	return s.mem[s.head : s.head+n]
+}
+
+// byteBudget is the number of bytes a read may scan
+type byteBudget struct {
+	limit int64
+	used  int64
+}
+
+// take returns how many of the n bytes can be read, and counts them as read
+func (b *byteBudget) take(n int64) int64 {
+	if left := b.limit - b.used; n > left {
+		n = left
+	}
+	b.used += n
+	return n
+}
+
+// grow doubles bufferSize, up to MAX_ADAPTIVE_LENGTH
+func (s *backwardScanner) grow() {
+	if s.bufferSize < MAX_ADAPTIVE_LENGTH {
+		s.bufferSize *= 2
+		if s.bufferSize > MAX_ADAPTIVE_LENGTH {
+			s.bufferSize = MAX_ADAPTIVE_LENGTH
+		}
+	}
+}
+
+// setLine sets the line found by scan
+func (s *backwardScanner) setLine(line []byte, line_start int64) {
+	// append line sep if the last line doesnt have it
+	if s.first_scan {
+		s.first_scan = false
+		if len(line) > 0 && !s.exact && !bytes.HasSuffix(line, s.delim) {
+			line = append(line[:len(line):len(line)], s.delim...)
+		}
+	}
+	s.line = line
+	s.line_start = line_start
+}
+
+// bytes returns the line found by the last call to scan
+func (s *backwardScanner) bytes() []byte {
+	return s.line
+}
+
+// offset returns the position in the source where the line starts
+func (s *backwardScanner) offset() int64 {
+	return s.line_start
+}
+
+// readErr returns the error that stopped the scanner, if any
+func (s *backwardScanner) readErr() error {
+	return s.err
+}
+
+// lastIndex returns the index of the last delimiter in buf that starts before unscanned and ends by search_end, or -1
+func lastIndex(buf []byte, delim []byte, unscanned int, search_end int) int {
+	limit := unscanned - 1 + len(delim)
+	if limit > search_end {
+		limit = search_end
+	}
+	if unscanned <= 0 || limit < len(delim) {
+		return -1
+	}
+	if len(delim) == 1 {
+		return bytes.LastIndexByte(buf[:limit], delim[0])
+	}
+	// bytes.LastIndex isn't vectorized, look for the last byte of the delimiter and check the rest
+	tail := len(delim) - 1
+	for limit >= len(delim) {
+		i := bytes.LastIndexByte(buf[tail:limit], delim[tail])
+		if i < 0 {
+			return -1
+		}
+		if bytes.Equal(buf[i:i+tail], delim[:tail]) {
+			return i
+		}
+		limit = i + tail
+	}
+	return -1
+}
@@ -0,0 +1,127 @@
+package gostan
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// source is anything the backward scanner can read from at any position
+type source interface {
+	size() (int64, error)
+	readAt(p []byte, off int64) (int, error)
+	name() string
+}
+
+// readFull reads exactly len(p) bytes at off. A short read means the source shrank since its size was taken,
+// the bytes past what was read aren't data
+func readFull(src source, p []byte, off int64) error {
+	n, err := src.readAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("read %d of %d bytes at %d of %s: %w", n, len(p), off, src.name(), err)
+}
+
+// mappedSource is a source that's all in memory, the scanner takes its lines from it in place
+type mappedSource interface {
+	source
+	mapped() []byte
+}
+
+// fileSource reads a local file
+type fileSource struct {
+	fd *os.File
+}
+
+func (f *fileSource) size() (int64, error) {
+	info, err := f.fd.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (f *fileSource) readAt(p []byte, off int64) (int, error) {
+	return f.fd.ReadAt(p, off)
+}
+
+func (f *fileSource) name() string {
+	return f.fd.Name()
+}
+
+// BlobClient is what the blob reader needs of an Azure blob client: its properties and range downloads.
+// *azblob.BlockBlobClient is one, tests can give a fake
+type BlobClient interface {
+	GetProperties(ctx context.Context, options *azblob.BlobGetPropertiesOptions) (azblob.BlobGetPropertiesResponse, error)
+	DownloadToBuffer(ctx context.Context, offset int64, count int64, b []byte, o azblob.DownloadOptions) error
+	URL() string
+}
+
+// how long an empty blob is waited for to get some content
+var (
+	blob_size_attempts   = 60
+	blob_size_retry_wait = 5 * time.Second
+)
+
+// blobSource reads an Azure blob with range downloads
+type blobSource struct {
+	blobClient     BlobClient
+	content_length int64           // -1 until the properties are fetched
+	ctx            context.Context // the requests are given up on once it's done
+}
+
+func newBlobSource(blobClient BlobClient) *blobSource {
+	return &blobSource{blobClient: blobClient, content_length: -1, ctx: context.Background()}
+}
+
+func (b *blobSource) size() (int64, error) {
+	if b.content_length >= 0 {
+		return b.content_length, nil
+	}
+
+	// check the file first see if it's not empty (or still empty)
+	prop, err := b.blobClient.GetProperties(b.ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	getSizeAttemptCount := 0
+	for (prop.ContentLength == nil || *prop.ContentLength <= 0) && getSizeAttemptCount < blob_size_attempts {
+		getSizeAttemptCount++
+		prop, err = b.blobClient.GetProperties(b.ctx, nil)
+		if err != nil {
+			return 0, err
+		}
+		// stop waiting once the read is given up on, e.g. at its MaxDuration
+		select {
+		case <-b.ctx.Done():
+			return 0, b.ctx.Err()
+		case <-time.After(blob_size_retry_wait):
+		}
+	}
+	if prop.ContentLength == nil {
+		return 0, fmt.Errorf("no content length for %s", b.name())
+	}
+	b.content_length = *prop.ContentLength
+	return b.content_length, nil
+}
+
+func (b *blobSource) readAt(p []byte, off int64) (int, error) {
+	err := b.blobClient.DownloadToBuffer(b.ctx, off, int64(len(p)), p, azblob.DownloadOptions{})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (b *blobSource) name() string {
+	return b.blobClient.URL()
+}
@@ -0,0 +1,69 @@
+package gostan
+
+import (
+	"context"
+	"os"
+)
+
+// RowFunc is called for every row that passes the ReadCondition, with its trailing delimiter.
+// The line is only valid during the call. Return stop to end the read, or an error to end it and have it returned
+type RowFunc func(line []byte) (stop bool, err error)
+
+// ReverseReadFilesFunc reads local file(s) from EOF and calls fn for every row, without goroutine or pipe.
+// It returns the Cursor where the read stopped
+func ReverseReadFilesFunc(readCondition *ReadCondition, fn RowFunc, file_descriptors ...*os.File) (Cursor, error) {
+	it := NewFileIterator(readCondition, file_descriptors...)
+	defer it.Close()
+	err := it.Each(fn)
+	return it.Cursor(), err
+}
+
+// ReverseReadBlobFunc reads file on Azure blob storage from EOF and calls fn for every row.
+// It returns the Cursor where the read stopped
+func ReverseReadBlobFunc(blobClient BlobClient, bufferSize int64, readCondition *ReadCondition, fn RowFunc) (Cursor, error) {
+	it := NewBlobIterator(blobClient, bufferSize, readCondition)
+	defer it.Close()
+	err := it.Each(fn)
+	return it.Cursor(), err
+}
+
+// Each calls fn for every remaining row until fn stops it, it returns the error of fn or of the read
+func (it *RecordIterator) Each(fn RowFunc) error {
+	for it.Next() {
+		stop, err := fn(it.Record().Data)
+		if err != nil {
+			return err
+		}
+		if stop {
+			return nil
+		}
+	}
+	return it.Err()
+}
+
+// Records sends the remaining rows to the returned channel, which holds up to size records (unbuffered if size <= 0).
+// The read waits while the channel is full so a slow consumer holds it back rather than piling rows up in memory.
+// The channel is closed at the end of the read or once ctx is done, check Err after that.
+// Record.Data is a copy, it stays valid
+func (it *RecordIterator) Records(ctx context.Context, size int) <-chan Record {
+	if size < 0 {
+		size = 0
+	}
+	records := make(chan Record, size)
+	it.setContext(ctx)
+	go func() {
+		defer close(records)
+		defer it.Close()
+		for it.Next() {
+			rec := it.Record()
+			rec.Data = append([]byte(nil), rec.Data...)
+			select {
+			case records <- rec:
+			case <-ctx.Done():
+				it.fail(ctx.Err())
+				return
+			}
+		}
+	}()
+	return records
+}
@@ -0,0 +1,244 @@
+package gostan
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// SummaryOptions tells Summarize what to look at. The rows are the ones the ReadCondition lets through,
+// RowLimit gives the last N of them
+type SummaryOptions struct {
+	TopK int // most frequent values kept per column, 5 by default
+	// TimeColumn and Window only summarize the rows within Window of the first (latest) time found in TimeColumn.
+	// The read stops at the first older row, rows whose time cannot be parsed are summarized
+	TimeColumn string
+	Window     time.Duration
+}
+
+// ColumnKind is what all the values of a column are
+type ColumnKind int
+
+const (
+	KindText ColumnKind = iota
+	KindNumber
+	KindDate
+)
+
+func (k ColumnKind) String() string {
+	switch k {
+	case KindText:
+		return "text"
+	case KindNumber:
+		return "number"
+	case KindDate:
+		return "date"
+	}
+	return fmt.Sprintf("ColumnKind(%d)", int(k))
+}
+
+// Summary describes the columns of the rows read
+type Summary struct {
+	Rows      int64 // rows summarized
+	Malformed int64 // rows that cannot be parsed into columns, they are only counted in Rows
+	Columns   []ColumnSummary
+}
+
+// ColumnSummary describes the values of a column, empty values aside
+type ColumnSummary struct {
+	Name     string
+	Kind     ColumnKind
+	Count    int64
+	Distinct int64
+	Min, Max string       // smallest and largest value of a number or date column, as they are written
+	Top      []ValueCount // most frequent values, most frequent first
+}
+
+// ValueCount is a value and how many times it's found
+type ValueCount struct {
+	Value string
+	Count int64
+}
+
+// SummarizeFiles summarizes the last rows of local file(s), given oldest first.
+// Unless ReadCondition.Header says otherwise, the first line of the files is their header
+func SummarizeFiles(readCondition *ReadCondition, opts SummaryOptions, file_descriptors ...*os.File) (*Summary, error) {
+	return NewFileIterator(withHeader(readCondition), file_descriptors...).Summarize(opts)
+}
+
+// SummarizeBlob summarizes the last rows of a file on Azure blob storage, like SummarizeFiles
+func SummarizeBlob(blobClient BlobClient, bufferSize int64, readCondition *ReadCondition, opts SummaryOptions) (*Summary, error) {
+	return NewBlobIterator(blobClient, bufferSize, withHeader(readCondition)).Summarize(opts)
+}
+
+// withHeader returns the ReadCondition with the header read, when it's only read for some conditions by default
+func withHeader(readCondition *ReadCondition) *ReadCondition {
+	if readCondition.headerMode() != HeaderDefault {
+		return readCondition
+	}
+	cond := *readCondition
+	cond.Header = HeaderFirstLine
+	return &cond
+}
+
+// Summarize reads the remaining rows and summarizes their columns, by header name or SelectColumns.
+// Without header, the columns are found in the rows: by position for CSV, top level fields for JSON Lines.
+// The header is only read if the ReadCondition needs it, see HeaderDefault. The iterator is closed once done
+func (it *RecordIterator) Summarize(opts SummaryOptions) (*Summary, error) {
+	defer it.Close()
+	if opts.TopK <= 0 {
+		opts.TopK = 5
+	}
+	s := newSummarizer(it.checker.outputHeader())
+	var latest time.Time
+	found_latest := false
+	for it.Next() {
+		rec := it.Record()
+		if rec.SchemaChange {
+			continue
+		}
+		r := it.checker.newRow(bytes.TrimSuffix(rec.Data, it.delim))
+		if opts.TimeColumn != "" && opts.Window > 0 {
+			v, _ := r.Column(opts.TimeColumn)
+			if t, ok := parseDate(v); ok {
+				if !found_latest {
+					latest, found_latest = t, true
+				} else if t.Before(latest.Add(-opts.Window)) {
+					break
+				}
+			}
+		}
+		s.add(r)
+	}
+	return s.summary(opts.TopK), it.Err()
+}
+
+// summarizer gathers the values of the columns
+type summarizer struct {
+	rows      int64
+	malformed int64
+	fixed     bool // the columns are known up front
+	names     []string
+	columns   map[string]*columnStats
+}
+
+type columnStats struct {
+	count              int64
+	counts             map[string]int64
+	number, date       bool // all values so far are
+	min_num, max_num   float64
+	min_date, max_date time.Time
+	min, max           [2]string // as written, for number and date
+}
+
+func newSummarizer(names []string) *summarizer {
+	s := &summarizer{fixed: len(names) > 0, columns: map[string]*columnStats{}}
+	for _, name := range names {
+		s.column(name)
+	}
+	return s
+}
+
+// column returns the stats of the column, added if it's new
+func (s *summarizer) column(name string) *columnStats {
+	c, ok := s.columns[name]
+	if !ok {
+		c = &columnStats{counts: map[string]int64{}, number: true, date: true}
+		s.columns[name] = c
+		s.names = append(s.names, name)
+	}
+	return c
+}
+
+func (s *summarizer) add(r Row) {
+	s.rows++
+	if r.Malformed() {
+		s.malformed++
+		return
+	}
+	if !s.fixed {
+		// the columns are whatever the row has
+		if r.cols.json {
+			keys := make([]string, 0, len(r.cols.values))
+			for key := range r.cols.values {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				s.column(key)
+			}
+		} else {
+			for i := range splitColumns(string(r.line), r.cols.sep) {
+				s.column(positionName(i))
+			}
+		}
+	}
+	for _, name := range s.names {
+		if v, ok := r.Column(name); ok {
+			s.columns[name].add(v)
+		}
+	}
+}
+
+func (c *columnStats) add(v string) {
+	if v == "" {
+		return
+	}
+	c.count++
+	c.counts[v]++
+	if c.number {
+		if f, ok := parseNumber(v); !ok {
+			c.number = false
+		} else {
+			if c.count == 1 || f < c.min_num {
+				c.min_num, c.min[0] = f, v
+			}
+			if c.count == 1 || f > c.max_num {
+				c.max_num, c.max[0] = f, v
+			}
+		}
+	}
+	if c.date {
+		if t, ok := parseDate(v); !ok {
+			c.date = false
+		} else {
+			if c.count == 1 || t.Before(c.min_date) {
+				c.min_date, c.min[1] = t, v
+			}
+			if c.count == 1 || t.After(c.max_date) {
+				c.max_date, c.max[1] = t, v
+			}
+		}
+	}
+}
+
+func (s *summarizer) summary(topK int) *Summary {
+	summary := &Summary{Rows: s.rows, Malformed: s.malformed, Columns: make([]ColumnSummary, len(s.names))}
+	for i, name := range s.names {
+		c := s.columns[name]
+		col := ColumnSummary{Name: name, Count: c.count, Distinct: int64(len(c.counts))}
+		switch {
+		case c.count == 0:
+		case c.number:
+			col.Kind, col.Min, col.Max = KindNumber, c.min[0], c.max[0]
+		case c.date:
+			col.Kind, col.Min, col.Max = KindDate, c.min[1], c.max[1]
+		}
+		for v, n := range c.counts {
+			col.Top = append(col.Top, ValueCount{Value: v, Count: n})
+		}
+		sort.Slice(col.Top, func(a, b int) bool {
+			if col.Top[a].Count != col.Top[b].Count {
+				return col.Top[a].Count > col.Top[b].Count
+			}
+			return col.Top[a].Value < col.Top[b].Value
+		})
+		if len(col.Top) > topK {
+			col.Top = col.Top[:topK]
+		}
+		summary.Columns[i] = col
+	}
+	return summary
+}
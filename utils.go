@@ -1,15 +1,38 @@
 package gostan
 
-func stringToMap(s string, header [][]byte) map[string]interface{} {
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+func stringToMap(s string, header [][]byte, sep byte) map[string]interface{} {
 
 	dat := make(map[string]interface{})
 
+	res := splitColumns(s, sep)
+	// without header the columns only go by position
+	if header != nil && len(header) != len(res) {
+		return nil
+	}
+	for i, v := range res {
+		dat[positionName(i)] = v
+	}
+	for i, key := range header {
+		dat[string(key)] = res[i]
+	}
+
+	return dat
+}
+
+// splitColumns splits the row at the separators that are not within quotes, the values keep their quotes
+func splitColumns(s string, sep byte) []string {
 	res := []string{}
 	var beg int
 	var inString bool
 
 	for i := 0; i < len(s); i++ {
-		if s[i] == ',' && !inString {
+		if s[i] == sep && !inString {
 			res = append(res, s[beg:i])
 			beg = i + 1
 		} else if s[i] == '"' {
@@ -20,13 +43,26 @@ func stringToMap(s string, header [][]byte) map[string]interface{} {
 			}
 		}
 	}
-	res = append(res, s[beg:])
-	if len(header) != len(res) {
-		return nil
-	}
-	for i, key := range header {
-		dat[string(key)] = res[i]
+	return append(res, s[beg:])
+}
+
+// parseNumber parses a number the way it's written in a text file. NaN and Inf are words there, not numbers
+func parseNumber(v string) (float64, bool) {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
 	}
+	return f, true
+}
 
-	return dat
+// joinKey joins the values of several columns into a map key. Every value is prefixed with its length,
+// so the keys of different values never collide whatever bytes they hold
+func joinKey(values []string) string {
+	var b strings.Builder
+	for _, v := range values {
+		b.WriteString(strconv.Itoa(len(v)))
+		b.WriteByte(':')
+		b.WriteString(v)
+	}
+	return b.String()
 }